@@ -0,0 +1,69 @@
+package session
+
+import "time"
+
+// Claims describes a token apart from its session data.
+type Claims struct {
+	Expires        time.Time
+	IssuedAt       time.Time // zero if not recorded
+	NotBefore      time.Time // zero if not set; see WithNotBefore
+	Audience       string    // see WithAudience
+	Subject        string    // see WithSubject
+	Issuer         string    // see Config.Issuer
+	Epoch          int       // see Config.Epoch
+	PayloadVersion int       // see Config.PayloadVersion
+	ID             string    // see EncodeOTT and Config.Tombstoned
+}
+
+// DecodeClaims decrypts token and returns its claims,
+// without decoding the session data.
+// It is cheaper than Decode for callers that need only
+// the claims, such as a rate limiter keyed by Subject.
+//
+// DecodeClaims rejects tokens that Decode would reject,
+// such as expired tokens.
+func DecodeClaims(token string, config *Config, opts ...Option) (*Claims, error) {
+	h, _, err := open(nil, token, config)
+	if err != nil {
+		return nil, err
+	}
+	err = checkClaims(h, config, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return h.claims(), nil
+}
+
+func (h *header) claims() *Claims {
+	c := &Claims{
+		Expires:        time.Unix(h.Expires, 0),
+		Audience:       h.Audience,
+		Subject:        h.Subject,
+		Issuer:         h.Issuer,
+		Epoch:          h.Epoch,
+		PayloadVersion: h.PayloadVersion,
+		ID:             h.ID,
+	}
+	if h.IssuedAt != 0 {
+		c.IssuedAt = time.Unix(h.IssuedAt, 0)
+	}
+	if h.NotBefore != 0 {
+		c.NotBefore = time.Unix(h.NotBefore, 0)
+	}
+	return c
+}
+
+// DecodeFull is like Decode, but it also returns
+// the token's claims, decrypting the token only once.
+// The claims are returned along with ErrExpiresSoon.
+func DecodeFull(token string, v interface{}, config *Config, opts ...Option) (*Claims, error) {
+	h, payload, err := open(nil, token, config)
+	if err != nil {
+		return nil, err
+	}
+	err = decodePayload(h, payload, v, config, newOptions(opts))
+	if err != nil && err != ErrExpiresSoon {
+		return nil, err
+	}
+	return h.claims(), err
+}
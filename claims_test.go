@@ -0,0 +1,136 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestDecodeClaims(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Issuer: "login",
+	}
+
+	type T struct {
+		UserID int
+		Name   string
+	}
+	start := time.Now().Truncate(time.Second)
+	token, err := EncodeWithOptions(T{UserID: 1, Name: "gopher"}, cfg,
+		WithSubject("1"),
+		WithExpiry(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := DecodeClaims(token, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "1" {
+		t.Errorf("Subject = %q, want %q", c.Subject, "1")
+	}
+	if c.Issuer != "login" {
+		t.Errorf("Issuer = %q, want %q", c.Issuer, "login")
+	}
+	if d := c.Expires.Sub(start); d < time.Hour || d > time.Hour+time.Second {
+		t.Errorf("Expires = start + %v, want start + %v", d, time.Hour)
+	}
+
+	expired, err := EncodeWithOptions(T{}, cfg, WithExpiry(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeClaims(expired, cfg); err == nil {
+		t.Errorf("DecodeClaims of expired token succeeded")
+	}
+}
+
+func TestDecodeFull(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Issuer: "login",
+		Epoch:  3,
+	}
+
+	type T struct {
+		Name string
+	}
+	start := time.Now().Truncate(time.Second)
+	token, err := EncodeWithOptions(T{Name: "gopher"}, cfg,
+		WithAudience("api"),
+		WithSubject("1"),
+		WithExpiry(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got T
+	c, err := DecodeFull(token, &got, cfg, WithAudience("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "gopher" {
+		t.Errorf("Name = %q, want %q", got.Name, "gopher")
+	}
+	if d := c.Expires.Sub(start); d < time.Hour || d > time.Hour+time.Second {
+		t.Errorf("Expires = start + %v, want start + %v", d, time.Hour)
+	}
+	if d := c.IssuedAt.Sub(start); d < 0 || d > time.Second {
+		t.Errorf("IssuedAt = start + %v, want start", d)
+	}
+	want := Claims{
+		Expires:  c.Expires,
+		IssuedAt: c.IssuedAt,
+		Audience: "api",
+		Subject:  "1",
+		Issuer:   "login",
+		Epoch:    3,
+	}
+	if *c != want {
+		t.Errorf("claims = %+v, want %+v", *c, want)
+	}
+}
+
+func TestPayloadVersion(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:           []*age.X25519Identity{key},
+		PayloadVersion: 2,
+	}
+	token, err := Encode(map[string]string{"name": "gopher"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.PayloadVersion = 3
+	var got map[string]string
+	c, err := DecodeFull(token, &got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PayloadVersion != 2 {
+		t.Errorf("PayloadVersion = %d, want 2", c.PayloadVersion)
+	}
+	if got["name"] != "gopher" {
+		t.Errorf("name = %q, want %q", got["name"], "gopher")
+	}
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// A Codec serializes session data into tokens and back.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec uses encoding/json.
+	// It is the default.
+	//
+	// Its output is canonical: the same value always
+	// serializes to the same bytes. Struct fields appear
+	// in declaration order and map keys, at any depth,
+	// in sorted order.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec uses encoding/gob.
+	// It preserves Go types that JSON handles poorly,
+	// such as interface values (whose concrete types must be
+	// registered with gob.Register), but its tokens can only
+	// be decoded by Go programs.
+	// Its output for maps depends on iteration order,
+	// so it is not canonical.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ContentHash returns a hash of v as serialized by
+// config's Codec. It depends only on the session data,
+// not on claims such as the expiry time, so callers can
+// compare hashes to skip writing a session that hasn't
+// changed. Hashes are stable across runs only if the
+// codec is canonical, as JSONCodec is.
+func ContentHash(v interface{}, config *Config) (string, error) {
+	b, err := config.codec().Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
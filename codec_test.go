@@ -0,0 +1,274 @@
+package session
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+type testShape interface {
+	Area() int
+}
+
+type testSquare struct {
+	Side int
+}
+
+func (s testSquare) Area() int { return s.Side * s.Side }
+
+func TestGobCodec(t *testing.T) {
+	gob.Register(testSquare{})
+
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:  []*age.X25519Identity{key},
+		Codec: GobCodec,
+	}
+
+	type T struct {
+		Login time.Time
+		Shape testShape
+	}
+	want := T{
+		Login: time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("X", 3600)),
+		Shape: testSquare{Side: 3},
+	}
+
+	token, err := Encode(want, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got T
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Login.Equal(want.Login) {
+		t.Errorf("Login = %v, want %v", got.Login, want.Login)
+	}
+	if got.Shape != want.Shape {
+		t.Errorf("Shape = %#v, want %#v", got.Shape, want.Shape)
+	}
+}
+
+// benchSession is a representative session payload.
+type benchSession struct {
+	UserID int64
+	Email  string
+	Roles  []string
+	CSRF   string
+	Login  time.Time
+}
+
+var benchCodecs = []struct {
+	name  string
+	codec Codec
+}{
+	{"JSON", JSONCodec},
+	{"Gob", GobCodec},
+}
+
+func benchConfig(b *testing.B, codec Codec) *Config {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &Config{
+		Keys:  []*age.X25519Identity{key},
+		Codec: codec,
+	}
+}
+
+var benchValue = benchSession{
+	UserID: 123456789,
+	Email:  "gopher@example.com",
+	Roles:  []string{"admin", "billing"},
+	CSRF:   "c2Vzc2lvbiBjc3JmIHRva2Vu",
+	Login:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := benchConfig(b, bc.codec)
+			var token string
+			for i := 0; i < b.N; i++ {
+				var err error
+				token, err = Encode(benchValue, cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(token)), "bytes/token")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := benchConfig(b, bc.codec)
+			token, err := Encode(benchValue, cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var v benchSession
+				err := Decode(token, &v, cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(token)), "bytes/token")
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	type T struct {
+		UserID int
+		Roles  map[string]bool
+	}
+	v := T{UserID: 1, Roles: map[string]bool{"admin": true, "billing": true}}
+
+	short := &Config{Cookie: &http.Cookie{MaxAge: 60}}
+	long := &Config{Cookie: &http.Cookie{MaxAge: 3600}}
+	h1, err := ContentHash(v, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := ContentHash(v, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 != h2 {
+		t.Errorf("hashes differ for sessions differing only in expiry: %s != %s", h1, h2)
+	}
+
+	v.UserID = 2
+	h3, err := ContentHash(v, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h3 == h1 {
+		t.Errorf("hashes equal for different sessions")
+	}
+}
+
+func TestJSONCodecCanonical(t *testing.T) {
+	type Inner struct {
+		Z, A int
+	}
+	type T struct {
+		B     string
+		A     map[string]interface{}
+		Inner Inner
+	}
+	newValue := func() T {
+		return T{
+			B: "b",
+			A: map[string]interface{}{
+				"z": 1,
+				"m": map[string]int{"y": 2, "x": 1, "w": 0},
+				"a": []interface{}{map[string]bool{"q": true, "p": false}},
+			},
+			Inner: Inner{Z: 26, A: 1},
+		}
+	}
+	const want = `{"B":"b","A":{"a":[{"p":false,"q":true}],"m":{"w":0,"x":1,"y":2},"z":1},"Inner":{"Z":26,"A":1}}`
+	for i := 0; i < 100; i++ {
+		b, err := JSONCodec.Marshal(newValue())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("got %s, want %s", b, want)
+		}
+	}
+}
+
+// stampCodec is an example codec for interoperating with a
+// system that writes times in its own layout. It converts
+// between testLogin and that system's JSON.
+type stampCodec struct{}
+
+const stampLayout = "02/01/2006 15:04:05"
+
+type testLogin struct {
+	User string
+	At   time.Time
+}
+
+type stampWire struct {
+	User string `json:"user"`
+	At   string `json:"at"`
+}
+
+func (stampCodec) Marshal(v interface{}) ([]byte, error) {
+	l := v.(testLogin)
+	return json.Marshal(stampWire{l.User, l.At.UTC().Format(stampLayout)})
+}
+
+func (stampCodec) Unmarshal(data []byte, v interface{}) error {
+	var w stampWire
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	at, err := time.Parse(stampLayout, w.At)
+	if err != nil {
+		return err
+	}
+	*v.(*testLogin) = testLogin{w.User, at}
+	return nil
+}
+
+func TestCustomTimeCodec(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:  []*age.X25519Identity{key},
+		Codec: stampCodec{},
+	}
+
+	// Mint a token the way the other system would,
+	// with its own time layout in the payload.
+	h := &header{Expires: time.Now().Add(time.Hour).Unix()}
+	payload := []byte(`{"user":"gopher","at":"31/12/2020 23:59:58"}`)
+	token, err := seal(h, payload, cfg.AgeRecipients(), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got testLogin
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := testLogin{"gopher", time.Date(2020, 12, 31, 23, 59, 58, 0, time.UTC)}
+	if got.User != want.User || !got.At.Equal(want.At) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	token, err = Encode(want, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = testLogin{}
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got.User != want.User || !got.At.Equal(want.At) {
+		t.Errorf("round trip: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/hkdf"
+)
+
+// deriveSalt separates keys from DeriveKey
+// from age's own uses of the file key.
+const deriveSalt = "github.com/kr/session DeriveKey"
+
+// DeriveKey returns a 32-byte secret bound to token,
+// derived with HKDF-SHA256 from the token's file key
+// (the random key age generates for each token) and info.
+// The same token and info always give the same secret;
+// different info gives an unrelated one.
+// It returns an error if token isn't valid under config.
+//
+// This is an advanced feature; use it with care.
+// Anyone who can decrypt token can derive the secret,
+// so it is only as private as config's keys.
+// Each token has its own file key, so a refreshed
+// or reissued token yields a different secret.
+// Use distinct info for each purpose.
+// Tokens accepted by Config.LegacyDecode have no file key
+// and are rejected.
+func DeriveKey(token string, info []byte, config *Config) ([]byte, error) {
+	ident, err := config.identities(nil)
+	if err != nil {
+		return nil, err
+	}
+	var fileKey []byte
+	var wrapped []age.Identity
+	for _, id := range ident {
+		wrapped = append(wrapped, &fileKeyIdentity{id, &fileKey})
+	}
+	h, _, err := decrypt(token, wrapped, config)
+	if err != nil {
+		return nil, err
+	}
+	err = checkClaims(h, config, newOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, fileKey, []byte(deriveSalt), info), key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// fileKeyIdentity records the file key
+// unwrapped by an identity.
+type fileKeyIdentity struct {
+	age.Identity
+	fileKey *[]byte
+}
+
+func (f *fileKeyIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	fileKey, err := f.Identity.Unwrap(stanzas)
+	if err == nil {
+		*f.fileKey = fileKey
+	}
+	return fileKey, err
+}
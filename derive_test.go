@@ -0,0 +1,63 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestDeriveKey(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1, err := DeriveKey(token, []byte("a"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := DeriveKey(token, []byte("a"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DeriveKey(token, []byte("b"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a1) != 32 {
+		t.Errorf("len = %d, want 32", len(a1))
+	}
+	if !bytes.Equal(a1, a2) {
+		t.Errorf("same info gave different keys")
+	}
+	if bytes.Equal(a1, b) {
+		t.Errorf("different info gave the same key")
+	}
+
+	// Another token for the same session has another file key.
+	token2, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := DeriveKey(token2, []byte("a"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a1, c) {
+		t.Errorf("different tokens gave the same key")
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeriveKey(token, []byte("a"), &Config{Keys: []*age.X25519Identity{other}}); err == nil {
+		t.Errorf("DeriveKey with the wrong key succeeded")
+	}
+}
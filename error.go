@@ -0,0 +1,55 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codes identifying why a token was rejected.
+// See Error.Code.
+const (
+	CodeNoCookie      = "no_cookie"       // metadata has no token; Get returns http.ErrNoCookie as is
+	CodeInvalidBase64 = "invalid_base64"  // token is not base64 or armor
+	CodeDecryptFailed = "decrypt_failed"  // token is not a valid age file
+	CodeUnknownKey    = "unknown_key"     // token is for other keys
+	CodeExpired       = "expired"         // token has expired
+	CodeNotBefore     = "not_before"      // token is not valid yet; see WithNotBefore
+	CodeBadAudience   = "bad_audience"    // token is for another audience
+	CodeBadIssuer     = "bad_issuer"      // see Config.RequireIssuer
+	CodeOldEpoch      = "old_epoch"       // see Config.Epoch
+	CodeWrongKind     = "wrong_kind"      // one-time token used as session, or vice versa
+	CodeTombstoned    = "tombstoned"      // see Config.Tombstoned
+	CodeBadClientCert = "bad_client_cert" // see Config.BindClientCert
+	CodePayloadError  = "payload_error"   // claims or session data are malformed
+)
+
+// An Error describes why a token was rejected.
+// Decoding functions return errors of type *Error
+// for problems with the token itself.
+type Error struct {
+	code string
+	err  error
+}
+
+func (e *Error) Error() string { return e.err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error { return e.err }
+
+// Code returns a stable identifier for the kind of problem,
+// one of the Code constants, suitable for aggregating
+// failures in logs and metrics.
+// Unlike error messages, codes won't change.
+func (e *Error) Code() string { return e.code }
+
+func codeError(code string, err error) error {
+	return &Error{code, err}
+}
+
+// invalid wraps err so that it matches ErrInvalid.
+func invalid(err error) error {
+	if errors.Is(err, ErrInvalid) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalid, err)
+}
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestErrorCode(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := EncodeWithOptions("foobar", cfg, WithExpiry(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notString, err := Encode(1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := Encode("foobar", &Config{Keys: []*age.X25519Identity{other}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ott, err := EncodeOTT("foobar", time.Hour, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindCfg := &Config{Keys: cfg.Keys, BindClientCert: true}
+	w := httptest.NewRecorder()
+	req := withClientCert(httptest.NewRequest("GET", "/", nil), "cert A")
+	if err := SetRequest(w, req, "foobar", bindCfg); err != nil {
+		t.Fatal(err)
+	}
+	bound := w.Result().Cookies()[0].Value
+	issuerCfg := &Config{Keys: cfg.Keys, RequireIssuer: "auth"}
+	epochCfg := &Config{Keys: cfg.Keys, Epoch: 1}
+
+	cases := []struct {
+		name   string
+		token  string
+		config *Config
+		opts   []Option
+		want   string
+	}{
+		{"base64", "!!!", cfg, nil, CodeInvalidBase64},
+		{"decrypt", encURL.EncodeToString([]byte("not an age file, but long enough to pass for one")), cfg, nil, CodeDecryptFailed},
+		{"truncated", token[:len(token)/2], cfg, nil, CodeDecryptFailed},
+		{"key", foreign, cfg, nil, CodeUnknownKey},
+		{"expired", expired, cfg, nil, CodeExpired},
+		{"audience", token, cfg, []Option{WithAudience("api")}, CodeBadAudience},
+		{"issuer", token, issuerCfg, nil, CodeBadIssuer},
+		{"epoch", token, epochCfg, nil, CodeOldEpoch},
+		{"kind", ott, cfg, nil, CodeWrongKind},
+		{"client cert", bound, bindCfg, nil, CodeBadClientCert},
+		{"payload", notString, cfg, nil, CodePayloadError},
+	}
+	for _, tc := range cases {
+		var got string
+		err := DecodeWithOptions(tc.token, &got, tc.config, tc.opts...)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: got error %v, want *Error", tc.name, err)
+			continue
+		}
+		if e.Code() != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, e.Code(), tc.want)
+		}
+	}
+
+	// Get's missing-cookie error stays comparable with ==.
+	var got string
+	err = Get(httptest.NewRequest("GET", "/", nil), &got, cfg)
+	if err != http.ErrNoCookie {
+		t.Errorf("Get without cookie = %v, want http.ErrNoCookie", err)
+	}
+
+	var e *Error
+
+	err = GetMetadata(map[string][]string{}, "authorization", &got, cfg)
+	if !errors.As(err, &e) || e.Code() != CodeNoCookie {
+		t.Errorf("GetMetadata without token = %v, want code %q", err, CodeNoCookie)
+	}
+
+	err = Decode(foreign, &got, cfg)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	expired, err := EncodeWithOptions("foobar", cfg, WithExpiry(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	err = Decode(expired, &got, cfg)
+	if !errors.Is(err, ErrExpired) || errors.Is(err, ErrInvalid) {
+		t.Errorf("expired: got %v, want ErrExpired", err)
+	}
+
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := encURL.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 1
+	tampered := encURL.EncodeToString(b)
+
+	// A plaintext too short to hold the expiry.
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, key.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte{0, 0, 0})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	truncated := encURL.EncodeToString(buf.Bytes())
+
+	for name, token := range map[string]string{
+		"garbage":   "!!!",
+		"tampered":  tampered,
+		"truncated": truncated,
+	} {
+		err := Decode(token, &got, cfg)
+		if !errors.Is(err, ErrInvalid) || errors.Is(err, ErrExpired) {
+			t.Errorf("%s: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncodeToFile encodes v as with Encode and writes the token
+// to the named file, followed by a newline.
+// The file always ends up with mode 0600, even if it
+// already existed, since the token grants access to
+// whatever it authenticates.
+func EncodeToFile(name string, v interface{}, config *Config) error {
+	token, err := Encode(v, config)
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it into place,
+	// so an existing file's mode never applies to the token
+	// and readers never see a partial write.
+	f, err := os.CreateTemp(filepath.Dir(name), ".token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	_, err = f.WriteString(token + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// DecodeFile reads a token from the named file
+// and decodes it into v as with Decode.
+// Leading and trailing white space in the file is ignored.
+func DecodeFile(name string, v interface{}, config *Config) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return Decode(strings.TrimSpace(string(b)), v, config)
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncodeToFileExistingMode(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	name := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(name, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeToFile(name, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("perm = %v, want %v", perm, os.FileMode(0600))
+	}
+	var got string
+	if err := DecodeFile(name, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	name := filepath.Join(t.TempDir(), "token")
+	if err := EncodeToFile(name, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("perm = %v, want %v", perm, os.FileMode(0600))
+	}
+
+	var got string
+	if err := DecodeFile(name, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	err = DecodeFile(filepath.Join(t.TempDir(), "missing"), &got, cfg)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
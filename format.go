@@ -0,0 +1,231 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// A token's plaintext comes in one of two versions.
+//
+// Version 0, the original format, is an 8-byte big-endian
+// expiry time (in Unix seconds) followed by the JSON-encoded
+// session data.
+//
+// Version 1 is a version byte (1), a flags byte, and a
+// uvarint-prefixed JSON object of claims, followed by the
+// session data. The claims include all of the token's
+// times (exp, iat, and nbf) as numeric Unix seconds,
+// so they are readable when inspecting a decrypted
+// token. Its first byte can't be mistaken for
+// version 0, whose expiry would have to be more than
+// 2^56 seconds away.
+//
+// Tokens that need no claims beyond their expiry
+// are written as version 0, so they can still be
+// read by servers running older code.
+
+// Flags in a version 1 header.
+const (
+	flagDeflate = 1 << iota // session data is compressed with DEFLATE
+)
+
+// header holds the claims carried by a token.
+type header struct {
+	Expires        int64  `json:"exp"`
+	IssuedAt       int64  `json:"iat,omitempty"` // only in version 1
+	NotBefore      int64  `json:"nbf,omitempty"`
+	Audience       string `json:"aud,omitempty"`
+	Subject        string `json:"sub,omitempty"`
+	Issuer         string `json:"iss,omitempty"`
+	Epoch          int    `json:"epoch,omitempty"`
+	PayloadVersion int    `json:"pv,omitempty"`
+	Type           string `json:"typ,omitempty"`
+	ID             string `json:"jti,omitempty"`
+	Once           bool   `json:"once,omitempty"`
+	CertHash       string `json:"cnf,omitempty"` // see Config.BindClientCert
+
+	flags byte
+}
+
+func (h *header) version() byte {
+	if h.Expires < 0 || h.Expires >= 1<<56 {
+		// Its first byte would not be 0.
+		return 1
+	}
+	if *h == (header{Expires: h.Expires, IssuedAt: h.IssuedAt}) {
+		return 0
+	}
+	return 1
+}
+
+// marshalPlaintext encodes h and payload.
+// If h asks for compression but it wouldn't make
+// payload smaller, it clears the flag in h
+// and stores payload as is.
+func marshalPlaintext(h *header, payload []byte) ([]byte, error) {
+	if h.flags&flagDeflate != 0 {
+		z, err := deflate(payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(z) < len(payload) {
+			payload = z
+		} else {
+			h.flags &^= flagDeflate
+		}
+	}
+	buf := new(bytes.Buffer)
+	if h.version() == 0 {
+		_ = binary.Write(buf, encBig, h.Expires)
+		buf.Write(payload)
+		return buf.Bytes(), nil
+	}
+	claims, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	var n [binary.MaxVarintLen64]byte
+	buf.WriteByte(1)
+	buf.WriteByte(h.flags)
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(claims)))])
+	buf.Write(claims)
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+func deflate(b []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = w.Write(b)
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parsePlaintext parses b into a header and payload.
+// It returns ErrInvalid if the token expires no later
+// than it was issued, or if the payload, after any
+// decompression, would be longer than maxPayload bytes.
+func parsePlaintext(b []byte, maxPayload int) (*header, []byte, error) {
+	if len(b) < 8 {
+		return nil, nil, errors.New("short header")
+	}
+	switch b[0] {
+	case 0:
+		if len(b)-8 > maxPayload {
+			return nil, nil, ErrInvalid
+		}
+		h := &header{Expires: int64(encBig.Uint64(b))}
+		return h, b[8:], nil
+	case 1:
+		// handled below
+	default:
+		return nil, nil, errors.New("unknown version")
+	}
+	h := &header{flags: b[1]}
+	n, k := binary.Uvarint(b[2:])
+	if k <= 0 || n > uint64(len(b)-2-k) {
+		return nil, nil, errors.New("bad header")
+	}
+	b = b[2+k:]
+	err := json.Unmarshal(b[:n], h)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.IssuedAt != 0 && h.Expires <= h.IssuedAt {
+		return nil, nil, ErrInvalid
+	}
+	payload := b[n:]
+	if h.flags&flagDeflate != 0 {
+		r := flate.NewReader(bytes.NewReader(payload))
+		payload, err = io.ReadAll(io.LimitReader(r, int64(maxPayload)+1))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(payload) > maxPayload {
+		return nil, nil, ErrInvalid
+	}
+	return h, payload, nil
+}
+
+// ageIntro begins every age file.
+const ageIntro = "age-encryption.org/v1\n"
+
+// compactMarker begins the ciphertext of a compact token,
+// in place of ageIntro.
+// It can't be mistaken for the start of an age file.
+const compactMarker = 1
+
+// minCiphertext is a lower bound on the length of
+// a token's ciphertext: a compact marker, a nonce,
+// and one sealed chunk holding at least the 8-byte expiry.
+const minCiphertext = 1 + 16 + 8 + 16
+
+// compactFrame returns ciphertext, an age file,
+// with compact framing.
+func compactFrame(ciphertext []byte) []byte {
+	if !bytes.HasPrefix(ciphertext, []byte(ageIntro)) {
+		return ciphertext
+	}
+	b := ciphertext[len(ageIntro)-1:]
+	b[0] = compactMarker
+	return b
+}
+
+// unframe returns a reader for the age file in r,
+// which may have compact framing.
+func unframe(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(1); err == nil && b[0] == compactMarker {
+		_, _ = br.Discard(1)
+		return io.MultiReader(strings.NewReader(ageIntro), br)
+	}
+	return br
+}
+
+// MinVersion returns the oldest wire format a decoder
+// must support to read token:
+//
+//	0  version 0 plaintext
+//	1  version 1 plaintext, with claims or compression
+//	2  compact framing (see Config.Compact)
+//
+// Each format was introduced after the one before it,
+// so a deployment can check that tokens it issues
+// don't exceed what all its servers can read.
+// MinVersion returns an error if token can't be decrypted.
+func MinVersion(token string, config *Config) (int, error) {
+	ident, err := config.identities(nil)
+	if err != nil {
+		return 0, err
+	}
+	h, _, err := decrypt(token, ident, config)
+	if err != nil {
+		return 0, err
+	}
+	if isCompact(token) {
+		return 2, nil
+	}
+	return int(h.version()), nil
+}
+
+// isCompact returns whether token uses compact framing.
+func isCompact(token string) bool {
+	if isArmored(token) {
+		return false
+	}
+	b, err := encRawURL.DecodeString(strings.TrimRight(token, "="))
+	return err == nil && len(b) > 0 && b[0] == compactMarker
+}
@@ -0,0 +1,309 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestParsePlaintextVersion0(t *testing.T) {
+	// Written the way Encode always did before version 1.
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, encBig, int64(1234))
+	_ = json.NewEncoder(buf).Encode("foobar")
+
+	h, payload, err := parsePlaintext(buf.Bytes(), defaultMaxPayloadLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Expires != 1234 {
+		t.Errorf("expires = %d, want 1234", h.Expires)
+	}
+	if got, want := string(payload), "\"foobar\"\n"; got != want {
+		t.Errorf("payload = %q, want %q", got, want)
+	}
+
+	if _, _, err := parsePlaintext(buf.Bytes(), 3); !errors.Is(err, ErrInvalid) {
+		t.Errorf("oversized payload: err = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestPlaintextRoundTrip(t *testing.T) {
+	cases := []*header{
+		{Expires: 1234},
+		{Expires: 1234, Audience: "api"},
+		{Expires: 1234, Subject: "user1"},
+	}
+	for _, h := range cases {
+		b, err := marshalPlaintext(h, []byte(`"foobar"`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b[0] != h.version() {
+			t.Errorf("version byte = %d, want %d", b[0], h.version())
+		}
+		got, payload, err := parsePlaintext(b, defaultMaxPayloadLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *h {
+			t.Errorf("header = %+v, want %+v", got, h)
+		}
+		if string(payload) != `"foobar"` {
+			t.Errorf("payload = %q, want %q", payload, `"foobar"`)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+	full, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Compact = true
+	compact, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := len(full) - len(compact); saved < 28 {
+		t.Errorf("compact token is %d bytes shorter, want at least 28", saved)
+	}
+
+	for _, token := range []string{full, compact} {
+		var got string
+		if err := Decode(token, &got, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got != "foobar" {
+			t.Errorf("got %q, want %q", got, "foobar")
+		}
+	}
+}
+
+func TestCompressOnlyIfSmaller(t *testing.T) {
+	cases := []struct {
+		payload  string
+		wantFlag bool
+	}{
+		{`"a"`, false},
+		{`"` + strings.Repeat("a", 1000) + `"`, true},
+	}
+	for _, test := range cases {
+		h := &header{Expires: 1234, flags: flagDeflate}
+		b, err := marshalPlaintext(h, []byte(test.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, payload, err := parsePlaintext(b, defaultMaxPayloadLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flag := got.flags&flagDeflate != 0; flag != test.wantFlag {
+			t.Errorf("payload len %d: compressed = %v, want %v", len(test.payload), flag, test.wantFlag)
+		}
+		if string(payload) != test.payload {
+			t.Errorf("payload = %q, want %q", payload, test.payload)
+		}
+	}
+}
+
+func TestExpiresBeforeIssued(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	now := time.Now().Unix()
+	for _, exp := range []int64{now - 60, now} {
+		// Audience forces version 1, which carries iat.
+		h := &header{Expires: exp, IssuedAt: now, Audience: "api"}
+		token, err := seal(h, []byte(`"foobar"`), []age.Recipient{key.Recipient()}, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		err = DecodeWithOptions(token, &got, cfg, WithAudience("api"), WithSkew(time.Hour))
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("exp = iat%+d: got %v, want ErrInvalid", exp-now, err)
+		}
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+	compact := &Config{Keys: cfg.Keys, Compact: true}
+
+	cases := []struct {
+		config *Config
+		opts   []Option
+		want   int
+	}{
+		{cfg, nil, 0},
+		{cfg, []Option{WithArmor()}, 0},
+		{cfg, []Option{WithAudience("api")}, 1},
+		{compact, nil, 2},
+		{compact, []Option{WithAudience("api")}, 2},
+	}
+	for _, tc := range cases {
+		token, err := EncodeWithOptions("foobar", tc.config, tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := MinVersion(token, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("MinVersion(%q) = %d, want %d", token, got, tc.want)
+		}
+	}
+}
+
+func TestTimeClaims(t *testing.T) {
+	// Version 0 keeps the expiry in the binary header.
+	b, err := marshalPlaintext(&header{Expires: 1234, IssuedAt: 1000}, []byte(`"foobar"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := int64(encBig.Uint64(b)); got != 1234 {
+		t.Errorf("v0 expiry = %d, want 1234", got)
+	}
+
+	// Version 1 keeps all times in the claims JSON.
+	h := &header{Expires: 1234, IssuedAt: 1000, NotBefore: 1100, Audience: "api"}
+	b, err = marshalPlaintext(h, []byte(`"foobar"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, k := binary.Uvarint(b[2:])
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b[2+k:2+k+int(n)], &claims); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{"exp": 1234, "iat": 1000, "nbf": 1100} {
+		if got, ok := claims[name].(float64); !ok || got != want {
+			t.Errorf("claims[%q] = %v, want %v", name, claims[name], want)
+		}
+	}
+	got, _, err := parsePlaintext(b, defaultMaxPayloadLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *h {
+		t.Errorf("got %+v, want %+v", got, h)
+	}
+
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		Now:  func() time.Time { return now },
+	}
+	for _, opts := range [][]Option{
+		nil,
+		{WithNotBefore(now.Add(time.Hour))},
+	} {
+		token, err := EncodeWithOptions("foobar", cfg, append(opts, WithExpiry(2*time.Hour))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := DecodeClaims(token, cfg, WithSkew(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := now.Add(2 * time.Hour).Unix(); c.Expires.Unix() != want {
+			t.Errorf("Expires = %v, want %v", c.Expires.Unix(), want)
+		}
+		if opts != nil && c.NotBefore.Unix() != now.Add(time.Hour).Unix() {
+			t.Errorf("NotBefore = %v, want %v", c.NotBefore, now.Add(time.Hour))
+		}
+	}
+
+	token, err := EncodeWithOptions("foobar", cfg, WithNotBefore(now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	err = Decode(token, &s, cfg)
+	var e *Error
+	if !errors.As(err, &e) || e.Code() != CodeNotBefore {
+		t.Errorf("Decode before nbf = %v, want code %q", err, CodeNotBefore)
+	}
+	now = now.Add(time.Hour)
+	if err := Decode(token, &s, cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLongMaxAge(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const year = 365 * 24 * 60 * 60
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "session", MaxAge: 400 * year},
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := DecodeClaims(token, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().Unix() + 399*year; c.Expires.Unix() < want {
+		t.Errorf("Expires = %v, want about 400 years from now", c.Expires)
+	}
+	touched, err := Touch(token, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := Decode(touched, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := Status(cfg).TTL; ttl <= 0 {
+		t.Errorf("Status TTL = %v, want positive", ttl)
+	}
+
+	// Expiries that don't fit version 0 use version 1.
+	for _, exp := range []int64{-1, 1 << 56} {
+		h := &header{Expires: exp}
+		b, err := marshalPlaintext(h, []byte(`"foobar"`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := parsePlaintext(b, defaultMaxPayloadLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Expires != exp {
+			t.Errorf("Expires = %d, want %d", got.Expires, exp)
+		}
+	}
+}
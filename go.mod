@@ -1,5 +1,8 @@
 module github.com/kr/session
 
-go 1.4
+go 1.16
 
-require filippo.io/age v1.0.0
+require (
+	filippo.io/age v1.0.0
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+)
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"net/http"
+	"time"
+)
+
+// An Option adjusts the behavior of a single call to
+// EncodeWithOptions or DecodeWithOptions.
+// Options that don't apply to a call are ignored.
+type Option func(*options)
+
+type options struct {
+	// decode
+	skew          time.Duration
+	strict        bool
+	expiryWarning time.Duration
+
+	// encode
+	expiry    *time.Duration
+	subject   string
+	notBefore time.Time
+	compress  bool
+	armor     bool
+
+	// both
+	audience string
+	once     bool                  // one-time token; see EncodeOTT
+	consume  func(h *header) error // called just before storing a decoded value; see DecodeOTT
+	req      *http.Request         // request being handled, if any
+}
+
+func newOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSkew tolerates clock skew of up to d between the server
+// that encoded a token and the one decoding it. A token is
+// still accepted up to d after it expires.
+func WithSkew(d time.Duration) Option {
+	return func(o *options) { o.skew = d }
+}
+
+// WithStrictPayload rejects tokens whose payload contains
+// object keys that don't match any field in the destination.
+// See encoding/json.Decoder.DisallowUnknownFields.
+// It has no effect with codecs other than JSONCodec.
+func WithStrictPayload() Option {
+	return func(o *options) { o.strict = true }
+}
+
+// WithExpiryWarning makes decoding a token that expires
+// within d return ErrExpiresSoon, after decoding it
+// successfully, as a hint to refresh the session.
+func WithExpiryWarning(d time.Duration) Option {
+	return func(o *options) { o.expiryWarning = d }
+}
+
+// WithExpiry sets an encoded token to expire d after it is
+// encoded, in place of the config's Cookie.MaxAge.
+func WithExpiry(d time.Duration) Option {
+	return func(o *options) { o.expiry = &d }
+}
+
+// WithAudience records aud as the intended audience of an
+// encoded token. When decoding, it requires the token's
+// audience to be aud.
+//
+// Tokens that record an audience can only be decoded with
+// the same audience, so they can't be mistaken for tokens
+// meant for something else, such as an ordinary session.
+func WithAudience(aud string) Option {
+	return func(o *options) { o.audience = aud }
+}
+
+// WithSubject records sub as the subject of an encoded token,
+// typically the user it was issued for.
+func WithSubject(sub string) Option {
+	return func(o *options) { o.subject = sub }
+}
+
+// WithNotBefore sets an encoded token not to be accepted
+// until t, such as for a session that starts later.
+func WithNotBefore(t time.Time) Option {
+	return func(o *options) { o.notBefore = t }
+}
+
+// WithCompression compresses the session data in an encoded
+// token, if that makes it smaller. It helps for large payloads
+// with repetitive contents.
+func WithCompression() Option {
+	return func(o *options) { o.compress = true }
+}
+
+// WithArmor encodes a token in the ASCII-armored format
+// used by the age command line tool, instead of base64.
+// Armored tokens span several lines, so they are suitable
+// for files and configuration but not for cookies.
+// Decoding detects armored tokens automatically.
+func WithArmor() Option {
+	return func(o *options) { o.armor = true }
+}
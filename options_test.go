@@ -0,0 +1,190 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestDecodeWithOptions(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "session", MaxAge: -1},
+	}
+
+	token, err := Encode(map[string]string{"V": "foobar", "W": "extra"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type T struct {
+		V string
+	}
+
+	var got T
+	if err := Decode(token, &got, cfg); err == nil {
+		t.Fatal("Decode of expired token succeeded")
+	}
+
+	err = DecodeWithOptions(token, &got, cfg, WithSkew(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.V != "foobar" {
+		t.Errorf("got %q, want %q", got.V, "foobar")
+	}
+
+	err = DecodeWithOptions(token, &got, cfg, WithSkew(time.Minute), WithStrictPayload())
+	if err == nil {
+		t.Fatal("strict decode of unknown field succeeded")
+	}
+}
+
+func TestEncodeWithOptions(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	type T struct {
+		V string
+	}
+	v := T{V: strings.Repeat("foobar", 100)}
+
+	plain, err := EncodeWithOptions(v, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := EncodeWithOptions(v, cfg,
+		WithAudience("api"),
+		WithSubject("user1"),
+		WithCompression(),
+		WithExpiry(-time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cookie != nil {
+		t.Errorf("EncodeWithOptions modified config")
+	}
+	if len(api) >= len(plain) {
+		t.Errorf("compressed token len = %d, want < %d", len(api), len(plain))
+	}
+
+	var got T
+	if err := Decode(plain, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %q, want %q", got.V, v.V)
+	}
+	if err := Decode(api, &got, cfg); err == nil {
+		t.Errorf("Decode of expired token succeeded")
+	}
+
+	got = T{}
+	err = DecodeWithOptions(api, &got, cfg, WithAudience("api"), WithSkew(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %q, want %q", got.V, v.V)
+	}
+
+	err = DecodeWithOptions(api, &got, cfg, WithSkew(time.Hour))
+	if err == nil {
+		t.Errorf("decode without audience succeeded")
+	}
+	err = DecodeWithOptions(plain, &got, cfg, WithAudience("api"))
+	if err == nil {
+		t.Errorf("decode of token without audience succeeded")
+	}
+}
+
+func TestMaxPayloadLen(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:          []*age.X25519Identity{key},
+		MaxPayloadLen: 10000,
+	}
+
+	big := strings.Repeat("a", 100000)
+	token, err := EncodeWithOptions(big, cfg, WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) > 2000 {
+		t.Fatalf("compressed token len = %d, want small", len(token))
+	}
+
+	var got string
+	if err := Decode(token, &got, cfg); !errors.Is(err, ErrInvalid) {
+		t.Errorf("err = %v, want %v", err, ErrInvalid)
+	}
+
+	plain, err := Encode(big, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(plain, &got, cfg); !errors.Is(err, ErrInvalid) {
+		t.Errorf("uncompressed: err = %v, want %v", err, ErrInvalid)
+	}
+
+	cfg.MaxPayloadLen = 0
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != big {
+		t.Errorf("got %d bytes, want %d", len(got), len(big))
+	}
+}
+
+func TestExpiryWarning(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	soon, err := EncodeWithOptions("foobar", cfg, WithExpiry(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	err = DecodeWithOptions(soon, &got, cfg, WithExpiryWarning(5*time.Minute))
+	if err != ErrExpiresSoon {
+		t.Errorf("got %v, want ErrExpiresSoon", err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	c, err := DecodeFull(soon, &got, cfg, WithExpiryWarning(5*time.Minute))
+	if err != ErrExpiresSoon || c == nil {
+		t.Errorf("DecodeFull = %v, %v, want claims and ErrExpiresSoon", c, err)
+	}
+
+	later, err := EncodeWithOptions("foobar", cfg, WithExpiry(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeWithOptions(later, &got, cfg, WithExpiryWarning(5*time.Minute)); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
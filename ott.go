@@ -0,0 +1,51 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// EncodeOTT encodes v into a one-time token that expires
+// after ttl, such as for a link in a confirmation email.
+// One-time tokens can only be decoded by DecodeOTT,
+// and sessions only by the other decoding functions,
+// so neither can be used in place of the other.
+func EncodeOTT(v interface{}, ttl time.Duration, config *Config) (string, error) {
+	o := newOptions([]Option{WithExpiry(ttl)})
+	o.once = true
+	return encode(nil, v, config, o)
+}
+
+// DecodeOTT decodes a one-time token made by EncodeOTT
+// into v. It calls config.Consume to ensure the token
+// is used only once, and returns an error if
+// config.Consume is nil.
+// It calls config.Consume only after decoding and
+// validating the session data, so a token that fails
+// to decode is not used up.
+func DecodeOTT(token string, v interface{}, config *Config) error {
+	if config.Consume == nil {
+		return errors.New("no Consume func")
+	}
+	h, payload, err := open(nil, token, config)
+	if err != nil {
+		return err
+	}
+	o := newOptions(nil)
+	o.once = true
+	o.consume = func(h *header) error {
+		return config.Consume(h.ID, time.Unix(h.Expires, 0))
+	}
+	return decodePayload(h, payload, v, config, o)
+}
+
+// newID returns a new random token ID.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestOTT(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used := map[string]bool{}
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		Consume: func(id string, exp time.Time) error {
+			if used[id] {
+				return errors.New("already used")
+			}
+			used[id] = true
+			return nil
+		},
+	}
+
+	token, err := EncodeOTT("foobar", time.Hour, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := Decode(token, &got, cfg); err == nil {
+		t.Errorf("Decode of one-time token succeeded")
+	}
+	if err := DecodeOTT(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	if err := DecodeOTT(token, &got, cfg); err == nil {
+		t.Errorf("second DecodeOTT succeeded")
+	}
+
+	expired, err := EncodeOTT("foobar", -time.Minute, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeOTT(expired, &got, cfg); err == nil {
+		t.Errorf("DecodeOTT of expired token succeeded")
+	}
+
+	session, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeOTT(session, &got, cfg); err == nil {
+		t.Errorf("DecodeOTT of session token succeeded")
+	}
+}
+
+func TestOTTFailureNotConsumed(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used := map[string]bool{}
+	errInvalidLink := errors.New("invalid link")
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		Consume: func(id string, exp time.Time) error {
+			if used[id] {
+				return errors.New("already used")
+			}
+			used[id] = true
+			return nil
+		},
+		Validate: func(v interface{}) error { return errInvalidLink },
+	}
+
+	token, err := EncodeOTT("foobar", time.Hour, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := "unchanged"
+	if err := DecodeOTT(token, &got, cfg); !errors.Is(err, errInvalidLink) {
+		t.Errorf("got %v, want %v", err, errInvalidLink)
+	}
+	if got != "unchanged" {
+		t.Errorf("failed DecodeOTT changed value to %q", got)
+	}
+	if len(used) != 0 {
+		t.Errorf("failed DecodeOTT consumed the token")
+	}
+
+	cfg.Validate = nil
+	if err := DecodeOTT(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+}
+
+func TestOTTNilDestination(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	cfg := &Config{
+		Keys:  []*age.X25519Identity{key},
+		Codec: GobCodec, // accepts a nil destination
+		Consume: func(id string, exp time.Time) error {
+			n++
+			return nil
+		},
+	}
+	token, err := EncodeOTT("foobar", time.Hour, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := DecodeOTT(token, nil, cfg); err == nil {
+			t.Errorf("DecodeOTT into nil succeeded")
+		}
+	}
+	if n != 0 {
+		t.Errorf("Consume called %d times, want 0", n)
+	}
+}
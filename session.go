@@ -12,22 +12,63 @@
 package session
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"reflect"
 	"strings"
 	"time"
 
 	"filippo.io/age"
+	"filippo.io/age/armor"
 )
 
 var (
-	encBig = binary.BigEndian
-	encURL = base64.URLEncoding
+	encBig    = binary.BigEndian
+	encURL    = base64.URLEncoding
+	encRawURL = base64.RawURLEncoding
 )
 
+// ErrTooLarge is returned by Set when the session cookie
+// would be too large for browsers to store.
+var ErrTooLarge = errors.New("too large")
+
+// Cookie size limits, counting the name and value.
+// Browsers store cookies of up to maxCookieSize.
+const (
+	maxCookieSize           = 4096
+	defaultSizeWarningLimit = 3800
+)
+
+const defaultMaxPayloadLen = 1 << 20
+
+const defaultScryptWorkFactor = 15
+
+// ErrInvalid is returned when decoding a malformed token,
+// including one that fails to decrypt or authenticate.
+// It may be wrapped; use errors.Is to test for it.
+var ErrInvalid = errors.New("invalid")
+
+// ErrExpired is returned when decoding a token that was valid
+// but has expired.
+// It may be wrapped; use errors.Is to test for it.
+var ErrExpired = errors.New("expired")
+
+// ErrExpiresSoon is returned when decoding succeeds,
+// but the token expires within the duration given
+// by WithExpiryWarning. Unlike other errors,
+// it means the destination holds the decoded value.
+var ErrExpiresSoon = errors.New("expires soon")
+
+// ErrUnknownKey is returned when decoding a token
+// that wasn't encrypted to any of the configured keys.
+// It may be wrapped in an *Error; use errors.Is to test for it.
+var ErrUnknownKey = errors.New("unknown key")
+
 // defaultCookie is the real value that never changes.
 var defaultCookie = DefaultCookie
 
@@ -45,6 +86,25 @@ var DefaultCookie = http.Cookie{
 	SameSite: http.SameSiteLaxMode,
 }
 
+// DevConfig returns a Config for local development
+// over plain HTTP, such as on localhost.
+// Its cookie is like DefaultCookie, but not Secure,
+// so browsers will send it without TLS.
+//
+// Don't use DevConfig in production.
+func DevConfig(keys ...*age.X25519Identity) *Config {
+	cookie := defaultCookie
+	cookie.Secure = false
+	cookie.SameSite = http.SameSiteLaxMode
+	return &Config{Keys: keys, Cookie: &cookie}
+}
+
+// A KeyProvider supplies keys for a Config.
+type KeyProvider interface {
+	Identities() ([]age.Identity, error)
+	Recipients() ([]age.Recipient, error)
+}
+
 type Config struct {
 	// Keys is used to encrypt and decrypt sessions.
 	//
@@ -58,19 +118,403 @@ type Config struct {
 	// See filippo.io/age.
 	Keys []*age.X25519Identity
 
+	// Recipients and Identities, if set, are used
+	// in addition to Keys, for other kinds of age keys,
+	// such as SSH keys from filippo.io/age/agessh.
+	// Tokens are encrypted to every key in Keys
+	// and Recipients, and decrypted with any key in
+	// Keys and Identities.
+	Recipients []age.Recipient
+	Identities []age.Identity
+
+	// KeyProvider, if non-nil, supplies keys
+	// in addition to Keys, such as from a secret manager.
+	// It is consulted on every encode and decode,
+	// so it should cache keys as appropriate.
+	KeyProvider KeyProvider
+
+	// Passphrase, if set, is used to encrypt tokens with
+	// age's scrypt recipient when there are no other keys,
+	// and is tried, after any other keys, to decrypt them.
+	// It should have high entropy, like a random key;
+	// scrypt makes guessing slow but can't save a weak one.
+	Passphrase string
+
+	// ScryptWorkFactor is the base-2 logarithm of the scrypt
+	// work factor for Passphrase. Decoding rejects tokens with
+	// a higher work factor, so that a forged token can't cost
+	// more to check. Each token decoded costs a full scrypt
+	// computation, so this trades resistance to guessing
+	// against time per request.
+	//
+	// If ScryptWorkFactor is zero, 15 is used,
+	// which takes tens of milliseconds on typical hardware.
+	ScryptWorkFactor int
+
+	// MaxKeysToTry, if positive, limits how many keys
+	// are tried when decrypting a token. Keys beyond the
+	// limit, in the order of Keys, Identities, KeyProvider,
+	// and Passphrase (or IdentitiesFunc), are ignored,
+	// and a token encrypted to none of the others fails
+	// with ErrUnknownKey.
+	//
+	// Each key tried costs an X25519 operation,
+	// so this caps the work an attacker can cause
+	// by sending garbage tokens.
+	MaxKeysToTry int
+
 	// Cookie controls encoding and decoding cookies, as in
 	// net/http, except that Cookie.Value is ignored.
 	// (The cookie value is provided by Set.)
 	//
 	// If Cookie is nil, DefaultCookie is used.
+	// If Cookie.Name is empty, DefaultCookie.Name is used.
 	Cookie *http.Cookie
+
+	// OldNames lists previous names of the session cookie.
+	// Get reads a cookie with one of these names
+	// if there is none named Cookie.Name.
+	// Set always uses Cookie.Name.
+	//
+	// This allows renaming the cookie without
+	// logging out everyone who has the old one.
+	OldNames []string
+
+	// RequireSecure, if true, causes Set to return an error
+	// if Cookie is not Secure, so that a production server
+	// misconfigured to send sessions over plain HTTP fails
+	// loudly. See also DevConfig.
+	RequireSecure bool
+
+	// RecipientsFunc and IdentitiesFunc, if non-nil,
+	// choose the keys for each request, in place of Keys.
+	// For example, a multi-tenant server might look up
+	// a separate set of keys for each host name.
+	//
+	// They are used by Get and SetRequest.
+	// Functions without a request, such as Encode,
+	// return an error if the corresponding func is set.
+	RecipientsFunc func(*http.Request) ([]age.Recipient, error)
+	IdentitiesFunc func(*http.Request) ([]age.Identity, error)
+
+	// Issuer, if set, is recorded in each token as the
+	// system that encoded it.
+	Issuer string
+
+	// RequireIssuer, if set, causes tokens to be rejected
+	// unless their Issuer was RequireIssuer.
+	RequireIssuer string
+
+	// Epoch is recorded in each token.
+	// Tokens from an earlier epoch are rejected,
+	// so incrementing Epoch invalidates every
+	// existing session at once.
+	Epoch int
+
+	// PayloadVersion is recorded in each token as the
+	// version of the application's session data.
+	// After changing the structure of its sessions,
+	// an application can increment PayloadVersion and use
+	// Claims.PayloadVersion to recognize and migrate older
+	// sessions. It is unrelated to the token format.
+	PayloadVersion int
+
+	// LegacyDecode, if non-nil, is tried when a token
+	// can't be decrypted, to accept tokens written in some
+	// other format, such as by a previous session system.
+	// It must authenticate value and return its session data,
+	// which is then decoded with Codec, and expiry time.
+	//
+	// New tokens are always written in this package's format,
+	// so once all legacy tokens have expired,
+	// LegacyDecode can be removed.
+	LegacyDecode func(value string) (payload []byte, exp time.Time, err error)
+
+	// MaxPayloadLen limits the size of session data,
+	// measured after any decompression, so a small
+	// compressed token can't expand to exhaust memory.
+	// Tokens exceeding it are rejected with ErrInvalid.
+	//
+	// If MaxPayloadLen is zero, 1 MiB is used.
+	MaxPayloadLen int
+
+	// Codec serializes session data.
+	// Servers sharing tokens must use the same Codec.
+	//
+	// If Codec is nil, JSONCodec is used.
+	Codec Codec
+
+	// SizeWarning, if non-nil, is called by Set when the
+	// cookie's name and value together are longer than
+	// SizeWarningLimit bytes, with their combined size.
+	// It gives notice before sessions grow past 4096 bytes,
+	// the most that browsers will store, at which point
+	// Set returns ErrTooLarge instead.
+	//
+	// If SizeWarningLimit is zero, 3800 is used.
+	SizeWarning      func(size int)
+	SizeWarningLimit int
+
+	// Compact, if true, makes tokens about 30 bytes shorter
+	// by omitting the version line that begins every age file.
+	// Compact tokens can't be decrypted by age tools
+	// directly, or by servers running older versions of
+	// this package. Decode accepts both forms regardless.
+	Compact bool
+
+	// Validate, if non-nil, checks decoded session data,
+	// for example that required fields are present.
+	// It is called with a pointer to the decoded value,
+	// of the same type as the destination passed to Decode.
+	// If it returns an error, decoding fails with that error
+	// and the destination is unchanged.
+	Validate func(v interface{}) error
+
+	// PostDecode, if non-nil, is called after Validate
+	// with a pointer to the decoded value, which it may
+	// modify, for example to normalize fields or fill in
+	// computed ones. The destination passed to Decode
+	// receives the modified value.
+	// If it returns an error, decoding fails with that error
+	// and the destination is unchanged.
+	PostDecode func(v interface{}) error
+
+	// Tombstoned, if non-nil, reports whether the token
+	// with ID jti has been invalidated, such as on logout,
+	// so decoding rejects it even before it expires.
+	// When Tombstoned is set, each encoded token gets
+	// a random ID, which DecodeClaims reports, for adding
+	// to a tombstone set before calling Clear.
+	// Tokens without an ID are not checked.
+	Tombstoned func(jti string) bool
+
+	// Consume is called by DecodeOTT with the ID and expiry
+	// of a one-time token. It must record that the token
+	// has been used, returning an error if it already was.
+	// Records can be discarded once the token expires.
+	Consume func(id string, exp time.Time) error
+
+	// BindClientCert, if true, binds each session to the TLS
+	// client certificate of the request that SetRequest is
+	// responding to, and Get rejects a bound session unless
+	// the request presents the same certificate.
+	// Sessions are left unbound if there's no client
+	// certificate, unless RequireClientCert is also true,
+	// in which case SetRequest returns an error instead
+	// and Get rejects unbound sessions.
+	//
+	// Bound sessions can't be decoded without a request,
+	// such as by Decode.
+	BindClientCert    bool
+	RequireClientCert bool
+
+	// RefreshThreshold is how close to expiring a session
+	// must be for Refresh to extend it.
+	// If RefreshThreshold is zero, half of Cookie.MaxAge
+	// is used.
+	RefreshThreshold time.Duration
+
+	// Now, if non-nil, returns the current time,
+	// for computing and checking expiry times.
+	// It lets tests use a fake clock.
+	// If Now is nil, time.Now is used.
+	Now func() time.Time
+
+	types map[string]func() interface{} // see RegisterType
+}
+
+// Clone returns a copy of c that can be modified
+// without affecting c. Its slices, map, and Cookie are
+// copied, but keys and funcs are shared.
+func (c *Config) Clone() *Config {
+	c2 := *c
+	c2.Keys = append([]*age.X25519Identity(nil), c.Keys...)
+	c2.Recipients = append([]age.Recipient(nil), c.Recipients...)
+	c2.Identities = append([]age.Identity(nil), c.Identities...)
+	c2.OldNames = append([]string(nil), c.OldNames...)
+	if c.Cookie != nil {
+		cookie := *c.Cookie
+		c2.Cookie = &cookie
+	}
+	if c.types != nil {
+		c2.types = map[string]func() interface{}{}
+		for tag, factory := range c.types {
+			c2.types[tag] = factory
+		}
+	}
+	return &c2
 }
 
 func (c *Config) cookie() http.Cookie {
 	if c.Cookie == nil {
 		return defaultCookie
 	}
-	return *c.Cookie
+	cookie := *c.Cookie
+	if cookie.Name == "" {
+		cookie.Name = defaultCookie.Name
+	}
+	return cookie
+}
+
+// refreshThreshold returns c.RefreshThreshold in seconds.
+// A MaxAge of more than about 292 years doesn't fit
+// in a time.Duration, so it avoids converting MaxAge.
+func (c *Config) refreshThreshold() int64 {
+	if c.RefreshThreshold == 0 {
+		return int64(c.cookie().MaxAge) / 2
+	}
+	return int64(c.RefreshThreshold / time.Second)
+}
+
+// expires returns the expiry time, in Unix seconds,
+// for a token issued at now with options o.
+// Like the original format, it adds MaxAge to the Unix
+// time directly, so any MaxAge works, even one too long
+// for a time.Duration.
+func (c *Config) expires(now time.Time, o *options) int64 {
+	if o.expiry != nil {
+		return now.Add(*o.expiry).Unix()
+	}
+	return now.Unix() + int64(c.cookie().MaxAge)
+}
+
+func (c *Config) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c *Config) codec() Codec {
+	if c.Codec == nil {
+		return JSONCodec
+	}
+	return c.Codec
+}
+
+// recipients returns the recipients to encrypt to
+// in a response to req, which may be nil.
+func (c *Config) recipients(req *http.Request) ([]age.Recipient, error) {
+	if c.RecipientsFunc != nil {
+		if req == nil {
+			return nil, errNoRequest
+		}
+		return c.RecipientsFunc(req)
+	}
+	var recip []age.Recipient
+	for _, key := range c.Keys {
+		recip = append(recip, key.Recipient())
+	}
+	recip = append(recip, c.Recipients...)
+	if c.KeyProvider != nil {
+		r, err := c.KeyProvider.Recipients()
+		if err != nil {
+			return nil, err
+		}
+		recip = append(recip, r...)
+	}
+	if len(recip) == 0 && c.Passphrase != "" {
+		// An scrypt recipient must be the only one.
+		r, err := age.NewScryptRecipient(c.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		r.SetWorkFactor(c.scryptWorkFactor())
+		recip = append(recip, r)
+	}
+	return recip, nil
+}
+
+// identities returns the identities to decrypt with
+// when handling req, which may be nil,
+// limited to c.MaxKeysToTry.
+func (c *Config) identities(req *http.Request) ([]age.Identity, error) {
+	ident, err := c.allIdentities(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxKeysToTry > 0 && len(ident) > c.MaxKeysToTry {
+		ident = ident[:c.MaxKeysToTry]
+	}
+	return ident, nil
+}
+
+func (c *Config) allIdentities(req *http.Request) ([]age.Identity, error) {
+	if c.IdentitiesFunc != nil {
+		if req == nil {
+			return nil, errNoRequest
+		}
+		return c.IdentitiesFunc(req)
+	}
+	var ident []age.Identity
+	for _, key := range c.Keys {
+		ident = append(ident, key)
+	}
+	ident = append(ident, c.Identities...)
+	if c.KeyProvider != nil {
+		id, err := c.KeyProvider.Identities()
+		if err != nil {
+			return nil, err
+		}
+		ident = append(ident, id...)
+	}
+	if c.Passphrase != "" {
+		id, err := age.NewScryptIdentity(c.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		id.SetMaxWorkFactor(c.scryptWorkFactor())
+		ident = append(ident, id)
+	}
+	return ident, nil
+}
+
+var errInsecure = errors.New("cookie is not secure")
+
+var errNoRequest = errors.New("keys depend on request")
+
+var errNotPointer = errors.New("destination is not a non-nil pointer")
+
+// AgeRecipients returns the age recipients that c encrypts
+// tokens to, for use with filippo.io/age directly.
+// It returns a new slice each time.
+// It returns nil if c uses RecipientsFunc
+// or if its KeyProvider fails.
+func (c *Config) AgeRecipients() []age.Recipient {
+	recip, _ := c.recipients(nil)
+	return recip
+}
+
+// AgeIdentities returns the age identities that c decrypts
+// tokens with, for use with filippo.io/age directly.
+// It returns a new slice each time.
+// It returns nil if c uses IdentitiesFunc
+// or if its KeyProvider fails.
+// It ignores MaxKeysToTry.
+func (c *Config) AgeIdentities() []age.Identity {
+	ident, _ := c.allIdentities(nil)
+	return ident
+}
+
+func (c *Config) maxPayloadLen() int {
+	if c.MaxPayloadLen == 0 {
+		return defaultMaxPayloadLen
+	}
+	return c.MaxPayloadLen
+}
+
+func (c *Config) scryptWorkFactor() int {
+	if c.ScryptWorkFactor == 0 {
+		return defaultScryptWorkFactor
+	}
+	return c.ScryptWorkFactor
+}
+
+func (c *Config) sizeWarningLimit() int {
+	if c.SizeWarningLimit == 0 {
+		return defaultSizeWarningLimit
+	}
+	return c.SizeWarningLimit
 }
 
 // Get decodes a session from req into v.
@@ -84,69 +528,567 @@ func (c *Config) cookie() http.Cookie {
 // any non-nil error should be treated simply
 // as an unauthenticated request
 // (e.g. a fresh visitor who hasn't logged in yet).
+// Use errors.Is to tell an expired session (ErrExpired)
+// from a malformed or tampered one (ErrInvalid).
+//
+// If req has no session cookie, Get returns
+// http.ErrNoCookie itself. Other errors from the token
+// are wrapped in *Error, which carries a reason code,
+// so they must be tested with errors.Is, not ==.
 func Get(req *http.Request, v interface{}, config *Config) error {
-	cookie, err := req.Cookie(config.cookie().Name)
+	_, _, err := get(req, v, config)
+	return err
+}
+
+// GetOrAnonymous is like Get, but it reports only whether
+// req has a valid session, following the advice for Get
+// to treat any error as an unauthenticated request.
+// If there is no valid session, it sets v to its zero value.
+func GetOrAnonymous(req *http.Request, v interface{}, config *Config) bool {
+	err := Get(req, v, config)
+	if err != nil {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		}
+		return false
+	}
+	return true
+}
+
+// Refresh is like Get, but it also extends the session
+// once it is close to expiring, giving an idle timeout
+// rather than a fixed one. If the session would expire
+// within config.RefreshThreshold, Refresh writes a new
+// cookie to w with the same session data and a fresh
+// expiry, as Set would. Otherwise it leaves w untouched,
+// so an active session isn't rewritten on every request.
+func Refresh(w http.ResponseWriter, req *http.Request, v interface{}, config *Config) error {
+	h, payload, err := get(req, v, config)
 	if err != nil {
 		return err
 	}
-	return Decode(cookie.Value, v, config)
+	if h.Expires-config.now().Unix() > config.refreshThreshold() {
+		return nil
+	}
+	if config.RequireSecure && !config.cookie().Secure {
+		return errInsecure
+	}
+	token, err := reseal(req, h, payload, newOptions(nil), config)
+	if err != nil {
+		return err
+	}
+	cookie := config.cookie()
+	cookie.Value = token
+	return writeCookie(w, &cookie, config)
+}
+
+// get decodes the session in req into v,
+// returning its header and encoded payload.
+func get(req *http.Request, v interface{}, config *Config) (*header, []byte, error) {
+	cookie, err := req.Cookie(config.cookie().Name)
+	for _, name := range config.OldNames {
+		if err != http.ErrNoCookie {
+			break
+		}
+		cookie, err = req.Cookie(name)
+	}
+	if err != nil {
+		return nil, nil, err // not wrapped, so callers can compare it
+	}
+	h, payload, err := open(req, cookie.Value, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := newOptions(nil)
+	o.req = req
+	err = decodePayload(h, payload, v, config, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, payload, nil
+}
+
+// GetMetadata decodes a session into v from the token
+// in md under key, such as in gRPC metadata.
+// Since gRPC lowercases metadata keys, GetMetadata also
+// tries key in lower case.
+func GetMetadata(md map[string][]string, key string, v interface{}, config *Config) error {
+	vals, ok := md[key]
+	if !ok {
+		vals = md[strings.ToLower(key)]
+	}
+	if len(vals) == 0 {
+		return codeError(CodeNoCookie, errors.New("no token in metadata"))
+	}
+	return Decode(vals[0], v, config)
 }
 
 // Set encodes a session from v into a cookie on w.
 // See encoding/json for encoding behavior.
+//
+// If w already has a Set-Cookie header for the session cookie,
+// Set replaces it, so calling Set more than once in a response
+// writes only the last session.
+// Like http.Header, Set is not safe to call concurrently
+// on the same ResponseWriter.
 func Set(w http.ResponseWriter, v interface{}, config *Config) error {
-	token, err := Encode(v, config)
+	return SetRequest(w, nil, v, config)
+}
+
+// SetRequest is like Set, but for a response to req.
+// It is needed when config chooses keys per request;
+// see Config.RecipientsFunc.
+func SetRequest(w http.ResponseWriter, req *http.Request, v interface{}, config *Config) error {
+	if config.RequireSecure && !config.cookie().Secure {
+		return errInsecure
+	}
+	cookie, err := encodeCookie(req, v, config)
 	if err != nil {
 		return err
 	}
+	return writeCookie(w, cookie, config)
+}
+
+// writeCookie sets cookie in w, checking its size.
+func writeCookie(w http.ResponseWriter, cookie *http.Cookie, config *Config) error {
+	size := len(cookie.Name) + len(cookie.Value)
+	if size > maxCookieSize {
+		return ErrTooLarge
+	}
+	if size > config.sizeWarningLimit() && config.SizeWarning != nil {
+		config.SizeWarning(size)
+	}
+	return setCookie(w.Header(), cookie)
+}
+
+// Clear deletes the session cookie, for example
+// to log a user out.
+// It writes a Set-Cookie header with an empty value,
+// MaxAge -1, and config's other cookie attributes,
+// replacing any session cookie already set in w,
+// so it takes precedence over an earlier call to Set.
+func Clear(w http.ResponseWriter, config *Config) error {
+	if config.RequireSecure && !config.cookie().Secure {
+		return errInsecure
+	}
 	cookie := config.cookie()
-	cookie.Value = token
+	cookie.Value = ""
+	cookie.MaxAge = -1
+	cookie.Expires = time.Time{}
 	return setCookie(w.Header(), &cookie)
 }
 
+// EncodeCookie encodes v into a cookie, as Set would,
+// for callers that need to store the cookie themselves.
+// The cookie's attributes come from config.Cookie,
+// and its MaxAge matches the token's expiry.
+func EncodeCookie(v interface{}, config *Config) (*http.Cookie, error) {
+	return encodeCookie(nil, v, config)
+}
+
+func encodeCookie(req *http.Request, v interface{}, config *Config) (*http.Cookie, error) {
+	token, err := encode(req, v, config, newOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	cookie := config.cookie()
+	cookie.Value = token
+	return &cookie, nil
+}
+
 // Decode decodes the encrypted token into v.
-// See encoding/json for decoding behavior.
+// See encoding/json for decoding behavior,
+// except that v is replaced entirely by the decoded value
+// (rather than merged with it), and only on success.
+// If Decode returns an error, v is unchanged.
 func Decode(token string, v interface{}, config *Config) error {
-	var ident []age.Identity
-	for _, key := range config.Keys {
-		ident = append(ident, key)
+	return DecodeWithOptions(token, v, config)
+}
+
+// DecodeWithOptions is like Decode, but its behavior
+// can be adjusted by opts.
+func DecodeWithOptions(token string, v interface{}, config *Config, opts ...Option) error {
+	h, payload, err := open(nil, token, config)
+	if err != nil {
+		return err
 	}
-	r, err := age.Decrypt(base64.NewDecoder(encURL, strings.NewReader(token)), ident...)
+	return decodePayload(h, payload, v, config, newOptions(opts))
+}
+
+// DecodeWith is like Decode, but it decrypts token with
+// exactly the given identities, and otherwise uses the
+// default settings, as for a zero Config.
+// It is meant for tools that inspect tokens from
+// many sources, rather than for servers.
+func DecodeWith(token string, v interface{}, identities ...age.Identity) error {
+	config := new(Config)
+	h, payload, err := decrypt(token, identities, config)
 	if err != nil {
 		return err
 	}
-	var expires int64
-	err = binary.Read(r, encBig, &expires)
+	return decodePayload(h, payload, v, config, newOptions(nil))
+}
+
+// DecodeKeyRecipient is like Decode, but it also returns
+// the public recipient string of the key that decrypted token,
+// for example to record in an audit log which key a session used.
+// It returns an error if that key has no recipient string,
+// as with keys other than X25519 keys.
+func DecodeKeyRecipient(token string, v interface{}, config *Config) (recipient string, err error) {
+	all, err := config.identities(nil)
+	if err != nil {
+		return "", err
+	}
+	var ident []age.Identity
+	for _, id := range all {
+		ident = append(ident, &keyIdentity{Identity: id})
+	}
+	h, payload, err := decrypt(token, ident, config)
+	if err != nil {
+		return "", err
+	}
+	// Find the recipient before touching v,
+	// so v is left unchanged if there isn't one.
+	for _, id := range ident {
+		k := id.(*keyIdentity)
+		if !k.matched {
+			continue
+		}
+		if x, ok := k.Identity.(*age.X25519Identity); ok {
+			recipient = x.Recipient().String()
+		}
+		break
+	}
+	if recipient == "" {
+		return "", errors.New("key has no recipient string")
+	}
+	err = decodePayload(h, payload, v, config, newOptions(nil))
+	if err != nil {
+		return "", err
+	}
+	return recipient, nil
+}
+
+// keyIdentity records whether an identity decrypted a token.
+type keyIdentity struct {
+	age.Identity
+	matched bool
+}
+
+func (k *keyIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	fileKey, err := k.Identity.Unwrap(stanzas)
+	k.matched = err == nil
+	return fileKey, err
+}
+
+// checkClaims returns an error if the token with header h
+// is not acceptable under config and o.
+func checkClaims(h *header, config *Config, o *options) error {
+	if config.now().Sub(time.Unix(h.Expires, 0)) > o.skew {
+		return codeError(CodeExpired, ErrExpired)
+	}
+	if h.NotBefore != 0 && time.Unix(h.NotBefore, 0).Sub(config.now()) > o.skew {
+		return codeError(CodeNotBefore, errors.New("not yet valid"))
+	}
+	if h.Audience != o.audience {
+		return codeError(CodeBadAudience, errors.New("wrong audience"))
+	}
+	if config.RequireIssuer != "" && h.Issuer != config.RequireIssuer {
+		return codeError(CodeBadIssuer, errors.New("wrong issuer"))
+	}
+	if h.Epoch < config.Epoch {
+		return codeError(CodeOldEpoch, errors.New("old epoch"))
+	}
+	if h.Once != o.once {
+		return codeError(CodeWrongKind, errors.New("wrong kind of token"))
+	}
+	if config.Tombstoned != nil && h.ID != "" && config.Tombstoned(h.ID) {
+		return codeError(CodeTombstoned, errors.New("tombstoned"))
+	}
+	err := checkClientCert(o.req, h, config)
+	if err != nil {
+		return codeError(CodeBadClientCert, err)
+	}
+	return nil
+}
+
+// decodePayload checks the claims in h against o
+// and decodes payload into v.
+// It modifies v only if it returns nil.
+func decodePayload(h *header, payload []byte, v interface{}, config *Config, o *options) error {
+	err := checkClaims(h, config, o)
 	if err != nil {
 		return err
 	}
-	if time.Since(time.Unix(expires, 0)) > 0 {
-		return errors.New("expired")
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		// Some codecs accept a nil destination,
+		// which would skip the checks below.
+		return errNotPointer
+	}
+	// Decode into a fresh value, so a codec error
+	// partway through can't leave v partly filled in.
+	var p interface{}
+	if h.Type != "" {
+		p, err = config.newTyped(h.Type, v)
+		if err != nil {
+			return codeError(CodePayloadError, invalid(err))
+		}
+	} else {
+		p = reflect.New(rv.Elem().Type()).Interface()
+	}
+	err = unmarshal(payload, p, config, o)
+	if err != nil {
+		return codeError(CodePayloadError, invalid(err))
+	}
+	if config.Validate != nil {
+		err = config.Validate(p)
+		if err != nil {
+			return codeError(CodePayloadError, err)
+		}
+	}
+	if config.PostDecode != nil {
+		err = config.PostDecode(p)
+		if err != nil {
+			return codeError(CodePayloadError, err)
+		}
+	}
+	if o.consume != nil {
+		err = o.consume(h)
+		if err != nil {
+			return err
+		}
+	}
+	if iv, ok := v.(*interface{}); ok && h.Type != "" {
+		*iv = p
+	} else {
+		rv.Elem().Set(reflect.ValueOf(p).Elem())
+	}
+	if o.expiryWarning > 0 && time.Unix(h.Expires, 0).Sub(config.now()) <= o.expiryWarning {
+		return ErrExpiresSoon
+	}
+	return nil
+}
+
+func unmarshal(payload []byte, v interface{}, config *Config, o *options) error {
+	codec := config.codec()
+	if o.strict && codec == JSONCodec {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	}
+	return codec.Unmarshal(payload, v)
+}
+
+// NeedsRefresh returns whether token expires within the given
+// duration from now, or has already expired.
+// It returns an error if token can't be decrypted.
+func NeedsRefresh(token string, within time.Duration, config *Config) (bool, error) {
+	h, _, err := open(nil, token, config)
+	if err != nil {
+		return false, err
+	}
+	return time.Unix(h.Expires, 0).Sub(config.now()) <= within, nil
+}
+
+// Touch returns a new token with the same contents as token,
+// but set to expire after config.Cookie.MaxAge from now,
+// as for a sliding session. It re-encrypts the session data
+// without decoding it.
+// Touch returns an error if token is not valid,
+// in particular if it has already expired.
+func Touch(token string, config *Config) (string, error) {
+	h, payload, err := open(nil, token, config)
+	if err != nil {
+		return "", err
+	}
+	err = checkClaims(h, config, newOptions(nil))
+	if err != nil {
+		return "", err
+	}
+	return reseal(nil, h, payload, newOptions(nil), config)
+}
+
+// Reissue returns a new token with the same session data
+// as token, such as to turn a session cookie into
+// a longer-lived bearer token for an API client.
+// The session data is re-encrypted without being decoded.
+//
+// Token must be valid as a session under config.
+// The new token's expiry, audience, and subject come from
+// opts, as for EncodeWithOptions, except that the subject
+// is kept if opts don't set one.
+// Reissue's parameters differ in order from the other
+// functions' because opts must come last.
+func Reissue(token string, config *Config, opts ...Option) (string, error) {
+	h, payload, err := open(nil, token, config)
+	if err != nil {
+		return "", err
+	}
+	err = checkClaims(h, config, newOptions(nil))
+	if err != nil {
+		return "", err
+	}
+	o := newOptions(opts)
+	h.Audience = o.audience
+	if o.subject != "" {
+		h.Subject = o.subject
+	}
+	if o.compress {
+		h.flags |= flagDeflate
+	}
+	return reseal(nil, h, payload, o, config)
+}
+
+// reseal encrypts a new token for the already decoded
+// header and payload, with a fresh expiry from now
+// as set by o, for a response to req, which may be nil.
+func reseal(req *http.Request, h *header, payload []byte, o *options, config *Config) (string, error) {
+	now := config.now()
+	h.Expires = config.expires(now, o)
+	h.IssuedAt = now.Unix()
+	if h.Expires <= h.IssuedAt {
+		h.IssuedAt = 0 // decoders reject exp <= iat
+	}
+	recip, err := config.recipients(req)
+	if err != nil {
+		return "", err
+	}
+	return seal(h, payload, recip, false, config.Compact)
+}
+
+// ValidAt returns whether token will be accepted at time t,
+// checking its expiry and its other claims as Decode would.
+// It returns an error if token can't be decrypted.
+func ValidAt(token string, t time.Time, config *Config) (bool, error) {
+	h, _, err := open(nil, token, config)
+	if err != nil {
+		return false, err
 	}
-	return json.NewDecoder(r).Decode(v)
+	c := config.Clone()
+	c.Now = func() time.Time { return t }
+	return checkClaims(h, c, newOptions(nil)) == nil, nil
+}
+
+// ValidAnyConfig returns the first of configs that accepts
+// token, for example to check that a token will be accepted
+// by either of two deployments with different keys.
+// If none accepts it, ValidAnyConfig returns the error
+// from the last one.
+func ValidAnyConfig(token string, configs ...*Config) (*Config, error) {
+	err := errors.New("no configs")
+	for _, config := range configs {
+		var h *header
+		h, _, err = open(nil, token, config)
+		if err == nil {
+			err = checkClaims(h, config, newOptions(nil))
+		}
+		if err == nil {
+			return config, nil
+		}
+	}
+	return nil, err
 }
 
 // Encode encodes a token set to expire after config.Cookie.MaxAge. This
 // is intended to be used with Decode. If using sessions, you probably
 // want to use Set. See encoding/json for encoding behavior.
 func Encode(v interface{}, config *Config) (string, error) {
-	expires := time.Now().Unix() + int64(config.cookie().MaxAge)
-	var recip []age.Recipient
-	for _, key := range config.Keys {
-		recip = append(recip, key.Recipient())
+	return EncodeWithOptions(v, config)
+}
+
+// EncodeWithOptions is like Encode, but its behavior
+// can be adjusted by opts.
+// Options never modify config.
+func EncodeWithOptions(v interface{}, config *Config, opts ...Option) (string, error) {
+	return encode(nil, v, config, newOptions(opts))
+}
+
+// encode encodes v for a response to req, which may be nil.
+func encode(req *http.Request, v interface{}, config *Config, o *options) (string, error) {
+	payload, err := config.codec().Marshal(v)
+	if err != nil {
+		return "", err
 	}
-	out := &strings.Builder{}
-	be := base64.NewEncoder(encURL, out)
-	enc, err := age.Encrypt(be, recip...)
+	return encodePayload(req, v, payload, config, o)
+}
+
+// encodePayload is like encode, but takes v already
+// marshaled into payload.
+func encodePayload(req *http.Request, v interface{}, payload []byte, config *Config, o *options) (string, error) {
+	now := config.now()
+	h := &header{
+		Expires:        config.expires(now, o),
+		IssuedAt:       now.Unix(),
+		Audience:       o.audience,
+		Issuer:         config.Issuer,
+		Epoch:          config.Epoch,
+		PayloadVersion: config.PayloadVersion,
+		Subject:        o.subject,
+		Type:           config.typeTag(v),
+	}
+	if h.Expires <= h.IssuedAt {
+		// Already expired; decoders would reject exp <= iat
+		// as malformed instead of merely expired.
+		h.IssuedAt = 0
+	}
+	if !o.notBefore.IsZero() {
+		h.NotBefore = o.notBefore.Unix()
+	}
+	if o.compress {
+		h.flags |= flagDeflate
+	}
+	if o.once {
+		h.Once = true
+	}
+	if o.once || config.Tombstoned != nil {
+		h.ID = newID()
+	}
+	if config.BindClientCert {
+		var err error
+		h.CertHash, err = clientCertHash(req, config)
+		if err != nil {
+			return "", err
+		}
+	}
+	recip, err := config.recipients(req)
+	if err != nil {
+		return "", err
+	}
+	return seal(h, payload, recip, o.armor, config.Compact)
+}
+
+// seal encrypts h and payload to recip,
+// returning the encoded token.
+// If armored is true, the token is ASCII-armored
+// instead of base64-encoded.
+// Otherwise, if compact is true, it uses compact framing.
+func seal(h *header, payload []byte, recip []age.Recipient, armored, compact bool) (string, error) {
+	plaintext, err := marshalPlaintext(h, payload)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := new(bytes.Buffer)
+	enc, err := age.Encrypt(ciphertext, recip...)
 	if err != nil {
 		return "", err
 	}
-	_ = binary.Write(enc, encBig, expires)
-	_ = json.NewEncoder(enc).Encode(v)
+	_, _ = enc.Write(plaintext)
 	err = enc.Close()
 	if err != nil {
 		return "", err
 	}
+	out := &strings.Builder{}
+	var be io.WriteCloser
+	if armored {
+		be = armor.NewWriter(out)
+	} else {
+		be = base64.NewEncoder(encURL, out)
+		if compact {
+			ciphertext = bytes.NewBuffer(compactFrame(ciphertext.Bytes()))
+		}
+	}
+	_, _ = ciphertext.WriteTo(be)
 	err = be.Close()
 	if err != nil {
 		return "", err
@@ -154,26 +1096,111 @@ func Encode(v interface{}, config *Config) (string, error) {
 	return out.String(), nil
 }
 
+// open decrypts token with the identities in config
+// for handling req, which may be nil,
+// returning its header and payload.
+// It doesn't check whether the token has expired.
+func open(req *http.Request, token string, config *Config) (*header, []byte, error) {
+	ident, err := config.identities(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, payload, err := decrypt(token, ident, config)
+	if err != nil && config.LegacyDecode != nil {
+		payload, exp, lerr := config.LegacyDecode(token)
+		if lerr == nil {
+			return &header{Expires: exp.Unix()}, payload, nil
+		}
+	}
+	return h, payload, err
+}
+
+// decrypt is like open, but uses the given identities
+// instead of those in config.
+func decrypt(token string, ident []age.Identity, config *Config) (*header, []byte, error) {
+	src, err := ciphertext(token)
+	if err != nil {
+		return nil, nil, codeError(CodeInvalidBase64, invalid(err))
+	}
+	r, err := age.Decrypt(src, ident...)
+	if _, ok := err.(*age.NoIdentityMatchError); ok {
+		return nil, nil, codeError(CodeUnknownKey, ErrUnknownKey)
+	} else if err != nil {
+		return nil, nil, codeError(CodeDecryptFailed, invalid(err))
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, codeError(CodeDecryptFailed, invalid(err))
+	}
+	h, payload, err := parsePlaintext(plaintext, config.maxPayloadLen())
+	if err != nil {
+		return nil, nil, codeError(CodePayloadError, invalid(err))
+	}
+	return h, payload, nil
+}
+
+// ciphertext returns a reader for the age file in token,
+// which may be armored or base64-encoded
+// with or without padding.
+func ciphertext(token string) (io.Reader, error) {
+	var b []byte
+	var err error
+	if isArmored(token) {
+		b, err = io.ReadAll(armor.NewReader(strings.NewReader(strings.TrimSpace(token))))
+	} else {
+		b, err = encRawURL.DecodeString(strings.TrimRight(token, "="))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < minCiphertext {
+		return nil, errors.New("short token")
+	}
+	return unframe(bytes.NewReader(b)), nil
+}
+
+// isArmored returns whether token is ASCII-armored.
+// It checks the entire first line, since a base64 token
+// can begin with the same dashes as the armor header.
+func isArmored(token string) bool {
+	token = strings.TrimSpace(token)
+	line := token
+	if i := strings.IndexByte(token, '\n'); i >= 0 {
+		line = token[:i]
+	}
+	return strings.TrimSpace(line) == armor.Header
+}
+
 // setCookie sets the given cookie in h.
 // If any existing Set-Cookie values have the same cookie name,
-// it replaces each one,
+// it replaces the first and deletes the rest,
 // otherwise it adds a new one.
+//
+// The cookie is rendered by net/http, so its attributes
+// always appear in the same order: Path, Domain, Expires,
+// Max-Age, HttpOnly, Secure, SameSite, and, with Go 1.23
+// and later, Partitioned.
 func setCookie(h http.Header, cookie *http.Cookie) error {
 	s := cookie.String()
 	if s == "" {
 		return errors.New("invalid")
 	}
 	didReplace := false
-	a := h["Set-Cookie"]
-	for i := range a {
-		if isValidCookie(a[i], cookie.Name) {
-			a[i] = s
+	var a []string
+	for _, v := range h["Set-Cookie"] {
+		if isValidCookie(v, cookie.Name) {
+			if didReplace {
+				continue
+			}
+			v = s
 			didReplace = true
 		}
+		a = append(a, v)
 	}
 	if !didReplace {
-		h.Add("Set-Cookie", s)
+		a = append(a, s)
 	}
+	h["Set-Cookie"] = a
 	return nil
 }
 
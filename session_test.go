@@ -1,9 +1,24 @@
 package session
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestEncodeDecodeRoundTrip(t *testing.T) {
@@ -35,3 +50,1698 @@ func TestEncodeDecodeRoundTrip(t *testing.T) {
 		t.Errorf("got %q, want %q", got.V, want.V)
 	}
 }
+
+func TestDecodeArmored(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	token, err := EncodeWithOptions("foobar", cfg, WithArmor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, armor.Header+"\n") {
+		t.Fatalf("token = %q, want armored", token)
+	}
+
+	for _, tok := range []string{token, "\n" + token} {
+		var got string
+		if err := Decode(tok, &got, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got != "foobar" {
+			t.Errorf("got %q, want %q", got, "foobar")
+		}
+	}
+}
+
+func TestIsArmored(t *testing.T) {
+	cases := []struct {
+		token string
+		want  bool
+	}{
+		{armor.Header + "\nYWdl\n" + armor.Footer + "\n", true},
+		{armor.Header + "\r\nYWdl\r\n" + armor.Footer + "\r\n", true},
+		{"YWdlLWVuY3J5cHRpb24ub3JnL3Yx", false},
+		// Valid base64 that happens to start like the armor header.
+		{"-----BEGIN", false},
+		{"-----BEGIN_AGE_ENCRYPTED_FILE-----", false},
+		{"-----BEGIN_AGE_ENCRYPTED_FILE-----\nYWdl", false},
+		{"", false},
+	}
+	for _, test := range cases {
+		if got := isArmored(test.token); got != test.want {
+			t.Errorf("isArmored(%q) = %v, want %v", test.token, got, test.want)
+		}
+	}
+}
+
+func TestDecodeKeyOverlap(t *testing.T) {
+	var keys []*age.X25519Identity
+	for i := 0; i < 3; i++ {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	a, b, c := keys[0], keys[1], keys[2]
+
+	token, err := Encode("foobar", &Config{Keys: []*age.X25519Identity{a, b}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	err = Decode(token, &got, &Config{Keys: []*age.X25519Identity{b}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	err = Decode(token, &got, &Config{Keys: []*age.X25519Identity{c}})
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestNeedsRefresh(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	cases := []struct {
+		ttl  time.Duration
+		want bool
+	}{
+		{24 * time.Hour, false},
+		{time.Minute, true},
+		{-time.Minute, true},
+	}
+	for _, test := range cases {
+		token, err := EncodeWithOptions("foobar", cfg, WithExpiry(test.ttl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := NeedsRefresh(token, time.Hour, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("NeedsRefresh(ttl %v) = %v, want %v", test.ttl, got, test.want)
+		}
+	}
+}
+
+func TestDevConfig(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DevConfig(key)
+	if len(cfg.Keys) != 1 || cfg.Keys[0] != key {
+		t.Errorf("Keys = %v, want [%v]", cfg.Keys, key)
+	}
+	cookie := cfg.cookie()
+	if cookie.Secure {
+		t.Errorf("Secure = true, want false")
+	}
+	if cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("SameSite = %v, want %v", cookie.SameSite, http.SameSiteLaxMode)
+	}
+	if cookie.Name != DefaultCookie.Name {
+		t.Errorf("Name = %q, want %q", cookie.Name, DefaultCookie.Name)
+	}
+	if !defaultCookie.Secure {
+		t.Errorf("DevConfig modified defaultCookie")
+	}
+}
+
+func TestSetSizeLimits(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warned int
+	cfg := &Config{
+		Keys:        []*age.X25519Identity{key},
+		SizeWarning: func(size int) { warned = size },
+	}
+
+	// Pad the payload so the cookie's name and value
+	// add up to about the given size.
+	empty, err := Encode("", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overhead := len(DefaultCookie.Name) + len(empty)
+	pad := func(size int) string {
+		return strings.Repeat("a", (size-overhead)*3/4)
+	}
+
+	cases := []struct {
+		size     int
+		wantWarn bool
+		wantErr  error
+	}{
+		{3000, false, nil},
+		{3900, true, nil},
+		{4200, false, ErrTooLarge},
+	}
+	for _, test := range cases {
+		warned = 0
+		w := httptest.NewRecorder()
+		err := Set(w, pad(test.size), cfg)
+		if err != test.wantErr {
+			t.Errorf("size %d: err = %v, want %v", test.size, err, test.wantErr)
+		}
+		if (warned != 0) != test.wantWarn {
+			t.Errorf("size %d: warned = %d, want warning %v", test.size, warned, test.wantWarn)
+		}
+		if test.wantWarn && (warned < 3800 || warned > maxCookieSize) {
+			t.Errorf("size %d: warned = %d, want in (3800, %d]", test.size, warned, maxCookieSize)
+		}
+		if hasCookie := len(w.Header()["Set-Cookie"]) > 0; hasCookie != (err == nil) {
+			t.Errorf("size %d: set cookie = %v, want %v", test.size, hasCookie, err == nil)
+		}
+	}
+}
+
+func TestDecodeShortTokens(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	for _, token := range []string{"", "!", "1", "YWJj", "AQ", "AQAAAA=="} {
+		var got string
+		err := Decode(token, &got, cfg)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("Decode(%q) = %v, want ErrInvalid", token, err)
+		}
+	}
+}
+
+func TestDecodeFormats(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	type T struct {
+		V string
+	}
+	want := T{V: "foobar"}
+
+	// Grow the payload until the token needs padding.
+	var padded string
+	for i := 0; i < 3; i++ {
+		want.V += "x"
+		padded, err = Encode(want, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(padded, "=") {
+			break
+		}
+	}
+	if !strings.HasSuffix(padded, "=") {
+		t.Fatalf("token %q has no padding", padded)
+	}
+	armored, err := EncodeWithOptions(want, cfg, WithArmor())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]string{
+		"padded":  padded,
+		"raw":     strings.TrimRight(padded, "="),
+		"armored": armored,
+	}
+	for name, token := range tokens {
+		var got T
+		if err := Decode(token, &got, cfg); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", name, got.V, want.V)
+		}
+	}
+}
+
+func TestGetOldNames(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+	cfg := &Config{
+		Keys:     []*age.X25519Identity{key},
+		Cookie:   &http.Cookie{Name: "__Host-session", Path: "/", Secure: true},
+		OldNames: []string{"legacy", DefaultCookie.Name},
+	}
+
+	w := httptest.NewRecorder()
+	if err := Set(w, "foobar", old); err != nil {
+		t.Fatal(err)
+	}
+	req := cookieRequest(w)
+
+	var got string
+	if err := Get(req, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	w = httptest.NewRecorder()
+	if err := Set(w, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	resp := http.Response{Header: w.Header()}
+	if c := resp.Cookies(); len(c) != 1 || c[0].Name != "__Host-session" {
+		t.Errorf("Set wrote %v, want one cookie named __Host-session", c)
+	}
+}
+
+func TestDecodeKeyRecipient(t *testing.T) {
+	old, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := Encode("foobar", &Config{Keys: []*age.X25519Identity{cur}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{old, cur},
+	}
+	var got string
+	recipient, err := DecodeKeyRecipient(token, &got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	if want := cur.Recipient().String(); recipient != want {
+		t.Errorf("recipient = %q, want %q", recipient, want)
+	}
+
+	// Keys from Identities are tried too.
+	recipient, err = DecodeKeyRecipient(token, &got, &Config{Identities: []age.Identity{old, cur}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := cur.Recipient().String(); recipient != want {
+		t.Errorf("recipient = %q, want %q", recipient, want)
+	}
+
+	// MaxKeysToTry applies.
+	limited := &Config{Keys: cfg.Keys, MaxKeysToTry: 1}
+	if _, err := DecodeKeyRecipient(token, &got, limited); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want ErrUnknownKey", err)
+	}
+
+	// A key without a recipient string is an error, not a panic,
+	// and leaves v unchanged.
+	pass := &Config{Passphrase: "correct horse battery staple", ScryptWorkFactor: 10}
+	token, err = Encode("barbaz", pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = "unchanged"
+	if _, err := DecodeKeyRecipient(token, &got, pass); err == nil {
+		t.Errorf("DecodeKeyRecipient with passphrase succeeded")
+	}
+	if got != "unchanged" {
+		t.Errorf("got %q, want %q", got, "unchanged")
+	}
+}
+
+func TestKeysPerRequest(t *testing.T) {
+	keys := map[string]*age.X25519Identity{}
+	for _, host := range []string{"a.example", "b.example"} {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[host] = key
+	}
+
+	cfg := &Config{
+		RecipientsFunc: func(req *http.Request) ([]age.Recipient, error) {
+			return []age.Recipient{keys[req.Host].Recipient()}, nil
+		},
+		IdentitiesFunc: func(req *http.Request) ([]age.Identity, error) {
+			return []age.Identity{keys[req.Host]}, nil
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://a.example/", nil)
+	if err := SetRequest(w, req, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	req = cookieRequest(w)
+	req.Host = "a.example"
+	var got string
+	if err := Get(req, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	req.Host = "b.example"
+	if err := Get(req, &got, cfg); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("cross-tenant Get: err = %v, want %v", err, ErrUnknownKey)
+	}
+
+	if _, err := Encode("foobar", cfg); err == nil {
+		t.Errorf("Encode without a request succeeded")
+	}
+}
+
+// cookieRequest returns a request carrying
+// the cookies set in w.
+func cookieRequest(w *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return req
+}
+
+func TestDecodeErrorLeavesValue(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	// encoding/json sets A before it finds that B is a string.
+	token, err := Encode(map[string]string{"A": "new", "B": "notint"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type T struct {
+		A string
+		B int
+	}
+	want := T{A: "old", B: 1}
+	got := want
+	if err := Decode(token, &got, cfg); err == nil {
+		t.Fatal("Decode succeeded, want error")
+	}
+	if got != want {
+		t.Errorf("after failed Decode, got %+v, want %+v", got, want)
+	}
+}
+
+func TestIssuer(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := Encode("foobar", &Config{
+		Keys:   []*age.X25519Identity{key},
+		Issuer: "a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		require string
+		wantErr bool
+	}{
+		{"", false},
+		{"a", false},
+		{"b", true},
+	}
+	for _, test := range cases {
+		cfg := &Config{
+			Keys:          []*age.X25519Identity{key},
+			RequireIssuer: test.require,
+		}
+		var got string
+		err := Decode(token, &got, cfg)
+		if (err != nil) != test.wantErr {
+			t.Errorf("RequireIssuer %q: err = %v, want error %v", test.require, err, test.wantErr)
+		}
+	}
+}
+
+func TestValidAt(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	token, err := EncodeWithOptions("foobar", cfg, WithExpiry(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cases := []struct {
+		t    time.Time
+		want bool
+	}{
+		{now, true},
+		{now.Add(59 * time.Minute), true},
+		{now.Add(61 * time.Minute), false},
+	}
+	for _, test := range cases {
+		got, err := ValidAt(token, test.t, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("ValidAt(%v) = %v, want %v", test.t.Sub(now), got, test.want)
+		}
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ValidAt(token, now, &Config{Keys: []*age.X25519Identity{other}})
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// Tokens that Decode rejects on a claim other than expiry.
+	later, err := EncodeWithOptions("foobar", cfg, WithNotBefore(now.Add(time.Hour)), WithExpiry(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := EncodeWithOptions("foobar", cfg, WithAudience("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &Config{Keys: cfg.Keys, RequireIssuer: "auth"}
+	epoch := &Config{Keys: cfg.Keys, Epoch: 1}
+	claimCases := []struct {
+		name   string
+		token  string
+		config *Config
+		t      time.Time
+		want   bool
+	}{
+		{"issuer", token, issuer, now, false},
+		{"epoch", token, epoch, now, false},
+		{"audience", api, cfg, now, false},
+		{"before nbf", later, cfg, now, false},
+		{"after nbf", later, cfg, now.Add(90 * time.Minute), true},
+	}
+	for _, test := range claimCases {
+		var s string
+		if test.t.Equal(now) && Decode(test.token, &s, test.config) == nil {
+			t.Errorf("%s: Decode succeeded", test.name)
+		}
+		got, err := ValidAt(test.token, test.t, test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s: ValidAt = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLegacyDecode(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stand-in for some other signed format.
+	mac := func(s string) string {
+		h := hmac.New(sha256.New, []byte("secret"))
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	legacy := `{"V":"foobar"}`
+	legacy += "." + mac(legacy)
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		LegacyDecode: func(value string) ([]byte, time.Time, error) {
+			i := strings.LastIndexByte(value, '.')
+			if i < 0 || !hmac.Equal([]byte(value[i+1:]), []byte(mac(value[:i]))) {
+				return nil, time.Time{}, errors.New("bad mac")
+			}
+			return []byte(value[:i]), time.Now().Add(time.Hour), nil
+		},
+	}
+
+	type T struct {
+		V string
+	}
+	var got T
+	if err := Decode(legacy, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got.V != "foobar" {
+		t.Errorf("got %q, want %q", got.V, "foobar")
+	}
+
+	if err := Decode(legacy+"0", &got, cfg); err == nil {
+		t.Errorf("Decode of tampered legacy value succeeded")
+	}
+
+	token, err := Encode(T{V: "foobar"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = T{}
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got.V != "foobar" {
+		t.Errorf("got %q, want %q", got.V, "foobar")
+	}
+}
+
+func TestEpoch(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:  []*age.X25519Identity{key},
+		Epoch: 1,
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Epoch = 2
+	if err := Decode(token, &got, cfg); err == nil {
+		t.Errorf("Decode of token from old epoch succeeded")
+	}
+
+	token, err = Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeCookie(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "sid", Path: "/app", MaxAge: 3600, HttpOnly: true},
+	}
+
+	start := time.Now()
+	cookie, err := EncodeCookie("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie.Name != "sid" || cookie.Path != "/app" || !cookie.HttpOnly {
+		t.Errorf("cookie = %v, want attributes from config", cookie)
+	}
+	if cookie.MaxAge != 3600 {
+		t.Errorf("MaxAge = %d, want 3600", cookie.MaxAge)
+	}
+
+	h, _, err := open(nil, cookie.Value, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl := time.Unix(h.Expires, 0).Sub(start)
+	if d := ttl - time.Duration(cookie.MaxAge)*time.Second; d < -time.Second || d > time.Second {
+		t.Errorf("token ttl = %v, want %ds", ttl, cookie.MaxAge)
+	}
+
+	var got string
+	if err := Decode(cookie.Value, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+}
+
+func TestSetTwice(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	w := httptest.NewRecorder()
+	http.SetCookie(w, &http.Cookie{Name: "other", Value: "x"})
+	if err := Set(w, "first", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(w, "second", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(w.Header()["Set-Cookie"]); n != 2 {
+		t.Fatalf("got %d Set-Cookie headers, want 2", n)
+	}
+	var got string
+	if err := Get(cookieRequest(w), &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "second" {
+		t.Errorf("got %q, want %q", got, "second")
+	}
+}
+
+func TestSetDuplicates(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	w := httptest.NewRecorder()
+	name := cfg.cookie().Name
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "stale1"})
+	http.SetCookie(w, &http.Cookie{Name: "other", Value: "x"})
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "stale2"})
+	if err := Set(w, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("got %d session cookies, want 1", n)
+	}
+	if n := len(w.Header()["Set-Cookie"]); n != 2 {
+		t.Fatalf("got %d Set-Cookie headers, want 2", n)
+	}
+	var got string
+	if err := Get(cookieRequest(w), &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+}
+
+func TestClear(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Path: "/app", Domain: "example.com", Secure: true, MaxAge: 3600},
+	}
+
+	w := httptest.NewRecorder()
+	if err := Set(w, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Clear(w, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != cfg.cookie().Name || c.Value != "" || c.MaxAge != -1 {
+		t.Errorf("got %s, want deletion of %s", c, cfg.cookie().Name)
+	}
+	if c.Path != "/app" || c.Domain != "example.com" || !c.Secure {
+		t.Errorf("got %s, want config's attributes", c)
+	}
+	var got string
+	if err := Get(cookieRequest(w), &got, cfg); err == nil {
+		t.Errorf("Get after Clear succeeded")
+	}
+
+	cfg.RequireSecure = true
+	cfg.Cookie.Secure = false
+	if err := Clear(httptest.NewRecorder(), cfg); err == nil {
+		t.Errorf("Clear of insecure cookie succeeded with RequireSecure")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type T struct {
+		UserID int
+	}
+	errNoUser := errors.New("no user id")
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		Validate: func(v interface{}) error {
+			if v.(*T).UserID == 0 {
+				return errNoUser
+			}
+			return nil
+		},
+	}
+
+	good, err := Encode(T{UserID: 1}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := Encode(T{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got T
+	if err := Decode(good, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != 1 {
+		t.Errorf("UserID = %d, want 1", got.UserID)
+	}
+	if err := Decode(bad, &got, cfg); !errors.Is(err, errNoUser) {
+		t.Errorf("err = %v, want %v", err, errNoUser)
+	}
+	if got.UserID != 1 {
+		t.Errorf("after failed Decode, UserID = %d, want 1", got.UserID)
+	}
+}
+
+func TestPostDecode(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type T struct {
+		Email  string
+		Domain string
+	}
+	errNoEmail := errors.New("no email")
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		PostDecode: func(v interface{}) error {
+			u := v.(*T)
+			if u.Email == "" {
+				return errNoEmail
+			}
+			u.Email = strings.ToLower(u.Email)
+			u.Domain = u.Email[strings.IndexByte(u.Email, '@')+1:]
+			return nil
+		},
+	}
+
+	token, err := Encode(T{Email: "Alice@Example.COM"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got T
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := T{Email: "alice@example.com", Domain: "example.com"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	bad, err := Encode(T{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(bad, &got, cfg); !errors.Is(err, errNoEmail) {
+		t.Errorf("got %v, want %v", err, errNoEmail)
+	}
+	if got != want {
+		t.Errorf("failed decode changed value to %+v", got)
+	}
+}
+
+func TestSetCookieAttributes(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+		Cookie: &http.Cookie{
+			Name:     "__Host-session",
+			Path:     "/",
+			Domain:   "example.com",
+			Expires:  time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+			MaxAge:   3600,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		},
+	}
+
+	w := httptest.NewRecorder()
+	if err := Set(w, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := w.Header().Get("Set-Cookie")
+	i := strings.IndexByte(got, ';')
+	if i < 0 {
+		t.Fatalf("Set-Cookie = %q, want attributes", got)
+	}
+	got = got[i:]
+	want := "; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 03:04:05 GMT; " +
+		"Max-Age=3600; HttpOnly; Secure; SameSite=Strict"
+	if got != want {
+		t.Errorf("attributes = %q, want %q", got, want)
+	}
+}
+
+func TestTopLevelValues(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	roundTrip := func(v, dst interface{}) {
+		t.Helper()
+		token, err := Encode(v, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Decode(token, dst, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var slice []string
+	roundTrip([]string{"a", "b"}, &slice)
+	if len(slice) != 2 || slice[0] != "a" || slice[1] != "b" {
+		t.Errorf("slice = %q, want [a b]", slice)
+	}
+
+	var s string
+	roundTrip("admin", &s)
+	if s != "admin" {
+		t.Errorf("string = %q, want %q", s, "admin")
+	}
+
+	var n int64
+	roundTrip(int64(1234567890123), &n)
+	if n != 1234567890123 {
+		t.Errorf("number = %d, want 1234567890123", n)
+	}
+
+	// A null payload decodes to the zero value.
+	slice = []string{"old"}
+	roundTrip(nil, &slice)
+	if slice != nil {
+		t.Errorf("null into slice = %q, want nil", slice)
+	}
+	n = 1
+	roundTrip(nil, &n)
+	if n != 0 {
+		t.Errorf("null into number = %d, want 0", n)
+	}
+}
+
+func TestEmptyCookieName(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Path: "/", MaxAge: 3600},
+	}
+
+	w := httptest.NewRecorder()
+	if err := Set(w, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Result().Cookies()
+	if len(c) != 1 || c[0].Name != DefaultCookie.Name {
+		t.Fatalf("Set wrote %v, want one cookie named %q", c, DefaultCookie.Name)
+	}
+
+	var got string
+	if err := Get(cookieRequest(w), &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+}
+
+func TestAgeKeys(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, cfg.AgeRecipients()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "other data")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := age.Decrypt(buf, cfg.AgeIdentities()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "other data" {
+		t.Errorf("got %q, want %q", got, "other data")
+	}
+
+	recip := cfg.AgeRecipients()
+	recip[0] = nil
+	if cfg.AgeRecipients()[0] == nil {
+		t.Errorf("AgeRecipients returned a shared slice")
+	}
+}
+
+func TestValidAnyConfig(t *testing.T) {
+	blueKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	greenKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blue := &Config{Keys: []*age.X25519Identity{blueKey}}
+	green := &Config{Keys: []*age.X25519Identity{greenKey}}
+
+	token, err := Encode("foobar", green)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ValidAnyConfig(token, blue, green)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != green {
+		t.Errorf("ValidAnyConfig returned blue, want green")
+	}
+
+	if _, err := ValidAnyConfig(token, blue); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestGetMetadata(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := map[string][]string{"x-session": {token}}
+	var got string
+	if err := GetMetadata(md, "X-Session", &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	if err := GetMetadata(md, "authorization", &got, cfg); err == nil {
+		t.Errorf("GetMetadata with missing key succeeded")
+	}
+}
+
+func TestRequireSecure(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DevConfig(key)
+	cfg.RequireSecure = true
+	w := httptest.NewRecorder()
+	if err := Set(w, "foobar", cfg); err == nil {
+		t.Errorf("Set of insecure cookie succeeded with RequireSecure")
+	}
+	if len(w.Header()["Set-Cookie"]) != 0 {
+		t.Errorf("Set wrote a cookie despite error")
+	}
+
+	cfg.Cookie = nil
+	if err := Set(httptest.NewRecorder(), "foobar", cfg); err != nil {
+		t.Errorf("Set of secure cookie: %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	key1, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key1},
+		Cookie: &http.Cookie{Name: "session", MaxAge: 60},
+	}
+	cfg.RegisterType("user", func() interface{} { return new(testUser) })
+
+	c := cfg.Clone()
+	c.Keys[0] = key2
+	c.Keys = append(c.Keys, key1)
+	c.Cookie.Name = "other"
+	c.Cookie.MaxAge = 3600
+	c.RegisterType("cart", func() interface{} { return new(testCart) })
+
+	if len(cfg.Keys) != 1 || cfg.Keys[0] != key1 {
+		t.Errorf("original Keys changed")
+	}
+	if cfg.Cookie.Name != "session" || cfg.Cookie.MaxAge != 60 {
+		t.Errorf("original Cookie changed to %v", cfg.Cookie)
+	}
+	if len(cfg.types) != 1 {
+		t.Errorf("original has %d types, want 1", len(cfg.types))
+	}
+}
+
+type fakeKeyProvider struct {
+	key *age.X25519Identity
+}
+
+func (p fakeKeyProvider) Identities() ([]age.Identity, error) {
+	return []age.Identity{p.key}, nil
+}
+
+func (p fakeKeyProvider) Recipients() ([]age.Recipient, error) {
+	return []age.Recipient{p.key.Recipient()}, nil
+}
+
+// countingIdentity counts calls to Unwrap.
+type countingIdentity struct {
+	age.Identity
+	n *int
+}
+
+func (c countingIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	*c.n++
+	return c.Identity.Unwrap(stanzas)
+}
+
+type countingKeyProvider struct {
+	keys []*age.X25519Identity
+	n    *int
+}
+
+func (p countingKeyProvider) Identities() ([]age.Identity, error) {
+	var ident []age.Identity
+	for _, key := range p.keys {
+		ident = append(ident, countingIdentity{key, p.n})
+	}
+	return ident, nil
+}
+
+func (p countingKeyProvider) Recipients() ([]age.Recipient, error) {
+	return nil, nil
+}
+
+func TestMaxKeysToTry(t *testing.T) {
+	var keys []*age.X25519Identity
+	for i := 0; i < 5; i++ {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	var n int
+	cfg := &Config{
+		KeyProvider:  countingKeyProvider{keys, &n},
+		MaxKeysToTry: 2,
+	}
+
+	// Encrypted to the last key, beyond the limit.
+	token, err := Encode("foobar", &Config{Keys: keys[4:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := Decode(token, &got, cfg); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+	if n != 2 {
+		t.Errorf("tried %d keys, want 2", n)
+	}
+
+	// Encrypted to a key within the limit.
+	token, err = Encode("foobar", &Config{Keys: keys[1:2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	if n := len(cfg.AgeIdentities()); n != len(keys) {
+		t.Errorf("AgeIdentities returned %d identities, want %d", n, len(keys))
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A low work factor keeps the test fast.
+	pass := &Config{Passphrase: "correct horse battery staple", ScryptWorkFactor: 10}
+	keys := &Config{Keys: []*age.X25519Identity{key}}
+	both := &Config{Keys: keys.Keys, Passphrase: pass.Passphrase, ScryptWorkFactor: 10}
+
+	for _, enc := range []*Config{pass, keys, both} {
+		token, err := Encode("foobar", enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if err := Decode(token, &got, both); err != nil {
+			t.Fatal(err)
+		}
+		if got != "foobar" {
+			t.Errorf("got %q, want %q", got, "foobar")
+		}
+	}
+
+	token, err := Encode("foobar", pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	wrong := &Config{Passphrase: "wrong", ScryptWorkFactor: 10}
+	if err := Decode(token, &got, wrong); err == nil {
+		t.Errorf("Decode with wrong passphrase succeeded")
+	}
+	cheap := &Config{Passphrase: pass.Passphrase, ScryptWorkFactor: 9}
+	if err := Decode(token, &got, cheap); err == nil {
+		t.Errorf("Decode with work factor above the limit succeeded")
+	}
+}
+
+func TestKeyProvider(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		KeyProvider: fakeKeyProvider{key},
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+
+	// The provider's key is interchangeable with Keys.
+	if err := Decode(token, &got, &Config{Keys: []*age.X25519Identity{key}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "session", MaxAge: 3600},
+	}
+
+	type T struct {
+		V string
+	}
+	token, err := EncodeWithOptions(T{V: "foobar"}, cfg, WithSubject("1"), WithExpiry(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	touched, err := Touch(token, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got T
+	c, err := DecodeFull(touched, &got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.V != "foobar" {
+		t.Errorf("got %q, want %q", got.V, "foobar")
+	}
+	if c.Subject != "1" {
+		t.Errorf("Subject = %q, want %q", c.Subject, "1")
+	}
+	if d := time.Until(c.Expires); d < 59*time.Minute {
+		t.Errorf("touched token expires in %v, want about 1h", d)
+	}
+
+	expired, err := EncodeWithOptions(T{V: "foobar"}, cfg, WithExpiry(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Touch(expired, cfg); err == nil {
+		t.Errorf("Touch of expired token succeeded")
+	}
+}
+
+func TestReissue(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "session", MaxAge: 60},
+	}
+
+	type T struct {
+		V string
+	}
+	cookie, err := EncodeWithOptions(T{V: "foobar"}, cfg, WithSubject("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer, err := Reissue(cookie, cfg, WithExpiry(30*24*time.Hour), WithAudience("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got T
+	c, err := DecodeFull(bearer, &got, cfg, WithAudience("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.V != "foobar" {
+		t.Errorf("got %q, want %q", got.V, "foobar")
+	}
+	if c.Subject != "1" {
+		t.Errorf("Subject = %q, want %q", c.Subject, "1")
+	}
+	if d := time.Until(c.Expires); d < 29*24*time.Hour {
+		t.Errorf("reissued token expires in %v, want about 30 days", d)
+	}
+	if err := Decode(bearer, &got, cfg); err == nil {
+		t.Errorf("Decode of bearer token without audience succeeded")
+	}
+
+	expired, err := EncodeWithOptions(T{V: "foobar"}, cfg, WithExpiry(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reissue(expired, cfg, WithExpiry(time.Hour)); err == nil {
+		t.Errorf("Reissue of expired token succeeded")
+	}
+}
+
+func TestDecodeWith(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := Encode("foobar", &Config{Keys: []*age.X25519Identity{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := DecodeWith(token, &got, key); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	if err := DecodeWith(token, &got, other); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestNow(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "session", MaxAge: 3600},
+		Now:    func() time.Time { return now },
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	now = now.Add(59 * time.Minute)
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if refresh, err := NeedsRefresh(token, 5*time.Minute, cfg); err != nil || !refresh {
+		t.Errorf("NeedsRefresh = %v, %v, want true", refresh, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := Decode(token, &got, cfg); !errors.Is(err, ErrExpired) {
+		t.Errorf("got %v, want ErrExpired", err)
+	}
+}
+
+func TestSSHKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recip, err := agessh.NewEd25519Recipient(sshPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident, err := agessh.NewEd25519Identity(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := &Config{
+		Keys:       []*age.X25519Identity{key},
+		Recipients: []age.Recipient{recip},
+	}
+	token, err := Encode("foobar", enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dec := range []*Config{
+		{Identities: []age.Identity{ident}},
+		{Keys: []*age.X25519Identity{key}},
+	} {
+		var got string
+		if err := Decode(token, &got, dec); err != nil {
+			t.Fatal(err)
+		}
+		if got != "foobar" {
+			t.Errorf("got %q, want %q", got, "foobar")
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key},
+		Cookie: &http.Cookie{Name: "session", MaxAge: 1800},
+		Now:    func() time.Time { return now },
+	}
+	w := httptest.NewRecorder()
+	if err := Set(w, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	req := cookieRequest(w)
+
+	// Early in the session's life, nothing is written.
+	now = now.Add(10 * time.Minute)
+	w = httptest.NewRecorder()
+	var got string
+	if err := Refresh(w, req, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	if n := len(w.Header()["Set-Cookie"]); n != 0 {
+		t.Errorf("got %d Set-Cookie headers, want 0", n)
+	}
+
+	// Past the threshold, the session is extended.
+	now = now.Add(10 * time.Minute)
+	w = httptest.NewRecorder()
+	if err := Refresh(w, req, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(w.Header()["Set-Cookie"]); n != 1 {
+		t.Fatalf("got %d Set-Cookie headers, want 1", n)
+	}
+	now = now.Add(25 * time.Minute)
+	got = ""
+	if err := Get(cookieRequest(w), &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	if err := Get(req, &got, cfg); !errors.Is(err, ErrExpired) {
+		t.Errorf("original session: got %v, want ErrExpired", err)
+	}
+}
+
+func TestGetOrAnonymous(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	type T struct {
+		UserID int
+	}
+	w := httptest.NewRecorder()
+	if err := Set(w, T{UserID: 1}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	var got T
+	if !GetOrAnonymous(cookieRequest(w), &got, cfg) {
+		t.Fatal("GetOrAnonymous = false, want true")
+	}
+	if got.UserID != 1 {
+		t.Errorf("UserID = %d, want 1", got.UserID)
+	}
+
+	if GetOrAnonymous(httptest.NewRequest("GET", "/", nil), &got, cfg) {
+		t.Errorf("GetOrAnonymous without cookie = true, want false")
+	}
+	if got != (T{}) {
+		t.Errorf("got %+v, want zero value", got)
+	}
+
+	got = T{UserID: 2}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetOrAnonymous(cookieRequest(w), &got, &Config{Keys: []*age.X25519Identity{other}}) {
+		t.Errorf("GetOrAnonymous with wrong key = true, want false")
+	}
+	if got != (T{}) {
+		t.Errorf("got %+v, want zero value", got)
+	}
+}
+
+func TestTombstoned(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tombstones := make(map[string]bool)
+	cfg := &Config{
+		Keys:       []*age.X25519Identity{key},
+		Tombstoned: func(jti string) bool { return tombstones[jti] },
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := Decode(token, &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Log out.
+	c, err := DecodeClaims(token, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == "" {
+		t.Fatal("token has no ID")
+	}
+	tombstones[c.ID] = true
+
+	err = Decode(token, &got, cfg)
+	var e *Error
+	if !errors.As(err, &e) || e.Code() != CodeTombstoned {
+		t.Errorf("Decode of tombstoned token = %v, want code %q", err, CodeTombstoned)
+	}
+	if err := Decode(other, &got, cfg); err != nil {
+		t.Errorf("Decode of other token: %v", err)
+	}
+}
+
+func TestValidateNilDestination(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var validated, postDecoded int
+	cfg := &Config{
+		Keys:  []*age.X25519Identity{key},
+		Codec: GobCodec, // accepts a nil destination
+		Validate: func(v interface{}) error {
+			validated++
+			return errors.New("rejected")
+		},
+		PostDecode: func(v interface{}) error {
+			postDecoded++
+			return nil
+		},
+	}
+	token, err := Encode("foobar", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(token, nil, cfg); err == nil {
+		t.Errorf("Decode into nil succeeded without Validate")
+	}
+	var s *string
+	if err := Decode(token, s, cfg); err == nil {
+		t.Errorf("Decode into nil pointer succeeded without Validate")
+	}
+	if err := Decode(token, "", cfg); err == nil {
+		t.Errorf("Decode into non-pointer succeeded without Validate")
+	}
+	if validated != 0 || postDecoded != 0 {
+		t.Errorf("hooks ran %d, %d times, want 0", validated, postDecoded)
+	}
+}
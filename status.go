@@ -0,0 +1,105 @@
+package session
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"filippo.io/age"
+)
+
+// StatusInfo describes a Config for diagnostic purposes,
+// such as a debugging endpoint.
+// It contains no secrets.
+type StatusInfo struct {
+	NumKeys    int
+	Recipients []string // public recipient of each key, in order
+	Primary    string   // recipient of the first key, or ""
+	TTL        time.Duration
+	CookieName string
+}
+
+// Status returns a description of config.
+// Its keys are those in Keys, Recipients, and KeyProvider
+// (if it doesn't fail), in that order. Keys without
+// a recipient string, such as SSH keys, are listed
+// by their Go type. Passphrase and RecipientsFunc
+// are left out.
+func Status(config *Config) StatusInfo {
+	cookie := config.cookie()
+	info := StatusInfo{
+		TTL:        ttl(cookie.MaxAge),
+		CookieName: cookie.Name,
+	}
+	var recip []age.Recipient
+	for _, key := range config.Keys {
+		recip = append(recip, key.Recipient())
+	}
+	recip = append(recip, config.Recipients...)
+	if config.KeyProvider != nil {
+		if r, err := config.KeyProvider.Recipients(); err == nil {
+			recip = append(recip, r...)
+		}
+	}
+	for _, r := range recip {
+		s, ok := r.(fmt.Stringer)
+		if ok {
+			info.Recipients = append(info.Recipients, s.String())
+		} else {
+			info.Recipients = append(info.Recipients, fmt.Sprintf("%T", r))
+		}
+	}
+	info.NumKeys = len(info.Recipients)
+	if len(info.Recipients) > 0 {
+		info.Primary = info.Recipients[0]
+	}
+	return info
+}
+
+// ttl converts maxAge to a Duration,
+// capping it at the largest Duration.
+func ttl(maxAge int) time.Duration {
+	if int64(maxAge) > math.MaxInt64/int64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(maxAge) * time.Second
+}
+
+// MaxKeys returns the largest number of X25519 keys config
+// could have such that encoding v would produce a token
+// of at most limit bytes. It helps plan how many keys
+// to keep during rotation.
+// The result counts the keys in config.Keys, but not its
+// other keys, from Recipients and KeyProvider, whose
+// overhead it includes. RecipientsFunc is ignored.
+func MaxKeys(v interface{}, config *Config, limit int) (int, error) {
+	// The token grows by the same amount for each key,
+	// so measure it with one key and with two.
+	var size [2]int
+	c := config.Clone()
+	c.Keys = nil
+	c.RecipientsFunc = nil
+	for i := range size {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			return 0, err
+		}
+		c.Keys = append(c.Keys, key)
+		token, err := Encode(v, c)
+		if err != nil {
+			return 0, err
+		}
+		b, err := encURL.DecodeString(token)
+		if err != nil {
+			return 0, err
+		}
+		size[i] = len(b)
+	}
+	perKey := size[1] - size[0]
+	base := size[0] - perKey
+	n := 0
+	for encURL.EncodedLen(base+(n+1)*perKey) <= limit {
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,172 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestStatus(t *testing.T) {
+	key1, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:   []*age.X25519Identity{key1, key2},
+		Cookie: &http.Cookie{Name: "sid", MaxAge: 3600},
+	}
+
+	got := Status(cfg)
+	if got.NumKeys != 2 {
+		t.Errorf("NumKeys = %d, want 2", got.NumKeys)
+	}
+	want := []string{key1.Recipient().String(), key2.Recipient().String()}
+	if len(got.Recipients) != len(want) {
+		t.Fatalf("Recipients = %q, want %q", got.Recipients, want)
+	}
+	for i := range want {
+		if got.Recipients[i] != want[i] {
+			t.Errorf("Recipients[%d] = %q, want %q", i, got.Recipients[i], want[i])
+		}
+	}
+	if got.Primary != want[0] {
+		t.Errorf("Primary = %q, want %q", got.Primary, want[0])
+	}
+	if got.TTL != time.Hour {
+		t.Errorf("TTL = %v, want %v", got.TTL, time.Hour)
+	}
+	if got.CookieName != "sid" {
+		t.Errorf("CookieName = %q, want %q", got.CookieName, "sid")
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	v := map[string]int{"UserID": 1}
+	cfg := &Config{}
+	n, err := MaxKeys(v, cfg, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 2 {
+		t.Fatalf("MaxKeys = %d, want at least 2", n)
+	}
+
+	size := func(n int) int {
+		c := &Config{}
+		for i := 0; i < n; i++ {
+			key, err := age.GenerateX25519Identity()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Keys = append(c.Keys, key)
+		}
+		token, err := Encode(v, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(token)
+	}
+	if got := size(n); got > 4096 {
+		t.Errorf("token with %d keys is %d bytes, want <= 4096", n, got)
+	}
+	if got := size(n + 1); got <= 4096 {
+		t.Errorf("token with %d keys is %d bytes, want > 4096", n+1, got)
+	}
+}
+
+func TestMaxKeysWithRecipients(t *testing.T) {
+	v := map[string]int{"UserID": 1}
+	n0, err := MaxKeys(v, &Config{}, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	for i := 0; i < 5; i++ {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Recipients = append(cfg.Recipients, key.Recipient())
+	}
+	n, err := MaxKeys(v, cfg, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != n0-5 {
+		t.Errorf("MaxKeys with 5 recipients = %d, want %d", n, n0-5)
+	}
+
+	c := cfg.Clone()
+	for i := 0; i < n; i++ {
+		key, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Keys = append(c.Keys, key)
+	}
+	token, err := Encode(v, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) > 4096 {
+		t.Errorf("token with %d keys and 5 recipients is %d bytes, want <= 4096", n, len(token))
+	}
+}
+
+func TestStatusOtherKeys(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recip, err := agessh.NewEd25519Recipient(sshPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provided, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:        []*age.X25519Identity{key},
+		Recipients:  []age.Recipient{recip},
+		KeyProvider: fakeKeyProvider{provided},
+	}
+	got := Status(cfg)
+	want := []string{
+		key.Recipient().String(),
+		"*agessh.Ed25519Recipient",
+		provided.Recipient().String(),
+	}
+	if got.NumKeys != len(want) {
+		t.Errorf("NumKeys = %d, want %d", got.NumKeys, len(want))
+	}
+	if len(got.Recipients) != len(want) {
+		t.Fatalf("Recipients = %q, want %q", got.Recipients, want)
+	}
+	for i := range want {
+		if got.Recipients[i] != want[i] {
+			t.Errorf("Recipients[%d] = %q, want %q", i, got.Recipients[i], want[i])
+		}
+	}
+}
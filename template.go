@@ -0,0 +1,57 @@
+package session
+
+import (
+	"reflect"
+	"sync"
+)
+
+// A Template holds session data for encoding into
+// many tokens, such as tokens for many clients that
+// share a payload but differ in expiry or ID.
+// It serializes the data once per codec and reuses
+// the result; each token is still encrypted afresh.
+//
+// The session data must not be modified after
+// NewTemplate is called.
+// A Template is safe for concurrent use.
+type Template struct {
+	v interface{}
+
+	mu      sync.Mutex
+	codec   Codec // codec that produced payload
+	payload []byte
+}
+
+// NewTemplate returns a Template for session data v.
+func NewTemplate(v interface{}) *Template {
+	return &Template{v: v}
+}
+
+// Encode is like EncodeWithOptions for t's session data.
+func (t *Template) Encode(config *Config, opts ...Option) (string, error) {
+	payload, err := t.marshal(config.codec())
+	if err != nil {
+		return "", err
+	}
+	return encodePayload(nil, t.v, payload, config, newOptions(opts))
+}
+
+func (t *Template) marshal(codec Codec) ([]byte, error) {
+	// Codecs of uncomparable types can't be
+	// checked against the cache, so they're never cached.
+	cacheable := reflect.TypeOf(codec).Comparable()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cacheable && t.payload != nil && t.codec == codec {
+		return t.payload, nil
+	}
+	payload, err := codec.Marshal(t.v)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable {
+		t.codec = codec
+		t.payload = payload
+	}
+	return payload, nil
+}
@@ -0,0 +1,110 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+// countingCodec counts calls to Marshal.
+type countingCodec struct {
+	n *int
+}
+
+func (c countingCodec) Marshal(v interface{}) ([]byte, error) {
+	*c.n++
+	return JSONCodec.Marshal(v)
+}
+
+func (c countingCodec) Unmarshal(data []byte, v interface{}) error {
+	return JSONCodec.Unmarshal(data, v)
+}
+
+func TestTemplate(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	cfg := &Config{
+		Keys:  []*age.X25519Identity{key},
+		Codec: countingCodec{&n},
+	}
+
+	type T struct {
+		V string
+	}
+	tmpl := NewTemplate(T{V: "foobar"})
+	short, err := tmpl.Encode(cfg, WithExpiry(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, err := tmpl.Encode(cfg, WithExpiry(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("marshaled %d times, want 1", n)
+	}
+	if short == long {
+		t.Errorf("tokens are identical")
+	}
+
+	for _, token := range []string{short, long} {
+		var got T
+		c, err := DecodeFull(token, &got, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.V != "foobar" {
+			t.Errorf("got %q, want %q", got.V, "foobar")
+		}
+		if token == long && time.Until(c.Expires) < 59*time.Minute {
+			t.Errorf("token expires in %v, want about 1h", time.Until(c.Expires))
+		}
+	}
+
+	// A different codec gets its own serialization.
+	gob := &Config{Keys: cfg.Keys, Codec: GobCodec}
+	token, err := tmpl.Encode(gob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got T
+	if err := Decode(token, &got, gob); err != nil {
+		t.Fatal(err)
+	}
+	if got.V != "foobar" {
+		t.Errorf("got %q, want %q", got.V, "foobar")
+	}
+}
+
+// BenchmarkTemplateEncode compares encoding with a Template
+// against calling Encode each time. Encryption dominates
+// the time; the savings show in allocations.
+func BenchmarkTemplateEncode(b *testing.B) {
+	for _, bc := range benchCodecs {
+		b.Run(bc.name+"/Encode", func(b *testing.B) {
+			cfg := benchConfig(b, bc.codec)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := Encode(benchValue, cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bc.name+"/Template", func(b *testing.B) {
+			cfg := benchConfig(b, bc.codec)
+			tmpl := NewTemplate(benchValue)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := tmpl.Encode(cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+var errNoClientCert = errors.New("no client certificate")
+
+// clientCertHash returns the hash of the TLS client
+// certificate presented with req, or "" if there is none
+// and config allows unbound sessions.
+func clientCertHash(req *http.Request, config *Config) (string, error) {
+	if req == nil || req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		if config.RequireClientCert {
+			return "", errNoClientCert
+		}
+		return "", nil
+	}
+	sum := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// checkClientCert returns an error if the session with
+// header h is bound to a client certificate other than
+// the one presented with req, which may be nil.
+func checkClientCert(req *http.Request, h *header, config *Config) error {
+	if h.CertHash == "" {
+		if config.BindClientCert && config.RequireClientCert {
+			return errNoClientCert
+		}
+		return nil
+	}
+	hash, err := clientCertHash(req, &Config{})
+	if err != nil {
+		return err
+	}
+	if hash != h.CertHash {
+		return errors.New("wrong client certificate")
+	}
+	return nil
+}
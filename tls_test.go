@@ -0,0 +1,72 @@
+package session
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func withClientCert(req *http.Request, raw string) *http.Request {
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Raw: []byte(raw)}},
+	}
+	return req
+}
+
+func TestBindClientCert(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys:           []*age.X25519Identity{key},
+		BindClientCert: true,
+	}
+
+	w := httptest.NewRecorder()
+	req := withClientCert(httptest.NewRequest("GET", "/", nil), "cert A")
+	if err := SetRequest(w, req, "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := Get(withClientCert(cookieRequest(w), "cert A"), &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got != "foobar" {
+		t.Errorf("got %q, want %q", got, "foobar")
+	}
+	if err := Get(withClientCert(cookieRequest(w), "cert B"), &got, cfg); err == nil {
+		t.Errorf("Get with a different certificate succeeded")
+	}
+	if err := Get(cookieRequest(w), &got, cfg); err == nil {
+		t.Errorf("Get without a certificate succeeded")
+	}
+	token := w.Result().Cookies()[0].Value
+	if err := Decode(token, &got, cfg); err == nil {
+		t.Errorf("Decode of bound token succeeded")
+	}
+
+	// Without a client certificate, the session is unbound
+	// unless one is required.
+	w = httptest.NewRecorder()
+	if err := SetRequest(w, httptest.NewRequest("GET", "/", nil), "foobar", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Get(cookieRequest(w), &got, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.RequireClientCert = true
+	if err := Get(cookieRequest(w), &got, cfg); err == nil {
+		t.Errorf("Get of unbound session succeeded with RequireClientCert")
+	}
+	err = SetRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "foobar", cfg)
+	if err == nil {
+		t.Errorf("SetRequest without a certificate succeeded with RequireClientCert")
+	}
+}
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"errors"
+	"reflect"
+)
+
+// RegisterType registers a type of session data under tag,
+// for servers that keep several types of session in tokens
+// with the same Config.
+// Factory must return a new pointer to a value of the type,
+// such as new(Cart).
+//
+// Encode records the tag of v's type in the token.
+// Decode checks that v has the type recorded in the token,
+// unless v is a *interface{}, in which case Decode
+// sets it to a new value from the matching factory.
+//
+// RegisterType must be called before config is used.
+// It panics if tag or the type is already registered,
+// or if factory doesn't return a pointer.
+func (c *Config) RegisterType(tag string, factory func() interface{}) {
+	if t := reflect.TypeOf(factory()); t == nil || t.Kind() != reflect.Ptr {
+		panic("session: factory for " + tag + " must return a pointer")
+	}
+	if c.types == nil {
+		c.types = map[string]func() interface{}{}
+	}
+	if _, ok := c.types[tag]; ok {
+		panic("session: type tag " + tag + " already registered")
+	}
+	if c.typeTag(factory()) != "" {
+		panic("session: type of " + tag + " already registered")
+	}
+	c.types[tag] = factory
+}
+
+// typeTag returns the registered tag for the type of v,
+// or its pointer type, or "" if there is none.
+func (c *Config) typeTag(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for tag, factory := range c.types {
+		if ft := reflect.TypeOf(factory()); ft == t || ft.Elem() == t {
+			return tag
+		}
+	}
+	return ""
+}
+
+// newTyped returns a new value for session data with
+// the given type tag, to be decoded in place of v.
+func (c *Config) newTyped(tag string, v interface{}) (interface{}, error) {
+	factory, ok := c.types[tag]
+	if !ok {
+		return nil, errors.New("unknown type " + tag)
+	}
+	p := factory()
+	if _, ok := v.(*interface{}); !ok && reflect.TypeOf(v) != reflect.TypeOf(p) {
+		return nil, errors.New("wrong type")
+	}
+	return p, nil
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"testing"
+
+	"filippo.io/age"
+)
+
+type testUser struct {
+	ID int
+}
+
+type testCart struct {
+	Items []string
+}
+
+func TestRegisterType(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Keys: []*age.X25519Identity{key},
+	}
+	cfg.RegisterType("user", func() interface{} { return new(testUser) })
+	cfg.RegisterType("cart", func() interface{} { return new(testCart) })
+
+	user, err := Encode(testUser{ID: 1}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := Encode(&testCart{Items: []string{"apple"}}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := Decode(user, &v, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := v.(*testUser); !ok || u.ID != 1 {
+		t.Errorf("user: got %#v, want &testUser{ID: 1}", v)
+	}
+	if err := Decode(cart, &v, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := v.(*testCart); !ok || len(c.Items) != 1 || c.Items[0] != "apple" {
+		t.Errorf("cart: got %#v, want &testCart{Items: [apple]}", v)
+	}
+
+	var u testUser
+	if err := Decode(user, &u, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 1 {
+		t.Errorf("ID = %d, want 1", u.ID)
+	}
+	if err := Decode(cart, &u, cfg); err == nil {
+		t.Errorf("Decode of cart into *testUser succeeded")
+	}
+}
+
+func TestRegisterTypeNonPointer(t *testing.T) {
+	cfg := new(Config)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterType with non-pointer factory didn't panic")
+		}
+		if len(cfg.types) != 0 {
+			t.Errorf("non-pointer factory was registered")
+		}
+	}()
+	cfg.RegisterType("cart", func() interface{} { return testCart{} })
+}
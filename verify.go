@@ -0,0 +1,45 @@
+package session
+
+import (
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+)
+
+// chunkSize is the size of a plaintext chunk in an age payload.
+const chunkSize = 64 * 1024
+
+// Verify checks that token decrypts and authenticates,
+// reading the entire payload, without decoding it
+// or checking any claims such as its expiry.
+//
+// It is a diagnostic aid for tokens corrupted in transit.
+// On failure, the error says where the problem is:
+// in the base64 or armor encoding, in the age header,
+// or in a payload chunk, along with the chunk's index.
+// Each chunk holds 64 KiB of plaintext.
+func Verify(token string, config *Config) error {
+	src, err := ciphertext(token)
+	if err != nil {
+		return codeError(CodeInvalidBase64, fmt.Errorf("base64: %w", err))
+	}
+	ident, err := config.identities(nil)
+	if err != nil {
+		return err
+	}
+	r, err := age.Decrypt(src, ident...)
+	if _, ok := err.(*age.NoIdentityMatchError); ok {
+		return codeError(CodeUnknownKey, fmt.Errorf("age header: %w", ErrUnknownKey))
+	} else if err != nil {
+		return codeError(CodeDecryptFailed, fmt.Errorf("age header: %w", err))
+	}
+	// The age stream returns no plaintext from a chunk
+	// until it has authenticated the whole chunk,
+	// so the bytes read so far locate the bad chunk.
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return codeError(CodeDecryptFailed, fmt.Errorf("payload chunk %d: %w", n/chunkSize, err))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestVerify(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Keys: []*age.X25519Identity{key}}
+
+	// Two chunks of plaintext.
+	token, err := Encode(strings.Repeat("x", chunkSize+100), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(token, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ct, err := encURL.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(i int) string {
+		b := append([]byte(nil), ct...)
+		if b[i] == 'A' {
+			b[i] = 'B'
+		} else {
+			b[i] = 'A'
+		}
+		return encURL.EncodeToString(b)
+	}
+	mac := bytes.Index(ct, []byte("\n--- ")) + len("\n--- ")
+	payload := bytes.IndexByte(ct[mac:], '\n') + mac + 1 + 16 // after the nonce
+
+	cases := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"base64", "!" + token[1:], "base64: "},
+		{"header", corrupt(mac), "age header: "},
+		{"chunk 0", corrupt(payload + 10), "payload chunk 0: "},
+		{"chunk 1", corrupt(len(ct) - 10), "payload chunk 1: "},
+	}
+	for _, tc := range cases {
+		err := Verify(tc.token, cfg)
+		if err == nil {
+			t.Errorf("%s: Verify succeeded", tc.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%s: got %q, want prefix %q", tc.name, err, tc.want)
+		}
+	}
+}